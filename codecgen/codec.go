@@ -1,55 +1,600 @@
 package codecgen
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"log"
+	"go/format"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"text/template"
+	"unicode"
 )
 
-func CodecExec(pTableName string, ps, ph int64, pd string, pName, pmode string) {
-	tablename := &pTableName
-	s := &ps
-	h := &ph
-	d := &pd
-	primaryAlisaName := &pName
-	mode := &pmode
-
-	if *tablename == "" {
-		fmt.Println("必须输入-t参数，db表名的意思")
-		return
-	}
-
-	if *s <= 0 && *h <= 0 {
-		fmt.Println("必须输入-s或者-d参数，优先级-s > -h，指定过期时间")
-		return
-	}
-	seconds := int64(60 * 60 * 3)
-	if *s > 0 {
-		seconds = int64(*s)
-	} else if *h > 0 {
-		seconds = int64(*h * 60 * 60)
-	} else {
-		fmt.Println("无法解析到过期时间参数")
-		return
-	}
-	tableName := *tablename
+// DefaultOutputDir codec 文件默认输出目录
+const DefaultOutputDir = "./rpc/server/internal/cache/codec"
+
+// Config codec 生成参数
+type Config struct {
+	TableName    string    `json:"table"`                   // db表名
+	Seconds      int64     `json:"seconds,omitempty"`       // 缓存过期时间，单位s
+	Hours        int64     `json:"hours,omitempty"`         // 缓存过期时间，单位小时
+	RedisDb      string    `json:"redis_db"`                // redis的那个模块的db
+	UniqueKey    string    `json:"unique_key,omitempty"`    // 唯一索引字段，默认id
+	Module       string    `json:"module,omitempty"`        // 项目go.mod的包名
+	OutputDir    string    `json:"output_dir,omitempty"`    // 生成文件的目录
+	Package      string    `json:"package,omitempty"`       // 生成文件的包名，为空时根据目录推导
+	Overwrite    Overwrite `json:"-"`                       // 文件已存在时的覆盖策略
+	Fields       []string  `json:"fields,omitempty"`        // 只查询这些列
+	Exclude      []string  `json:"exclude,omitempty"`       // 查询时排除这些列
+	Where        []string  `json:"where,omitempty"`         // One/FindAll 额外的静态查询条件
+	SoftDelete   string    `json:"soft_delete,omitempty"`   // 软删除列的处理方式: auto,off
+	NotFound     bool      `json:"not_found,omitempty"`     // 缓存不存在的记录，One 返回 NotFoundError
+	NotFoundTtl  int64     `json:"not_found_ttl,omitempty"` // 不存在记录的缓存时间，单位s
+	Jitter       int       `json:"jitter,omitempty"`        // 过期时间随机抖动的百分比
+	TtlHook      bool      `json:"ttl_hook,omitempty"`      // 生成 TtlOf 方法，按记录决定过期时间
+	Tier         string    `json:"tier,omitempty"`          // 缓存层级: redis,local
+	LocalSize    int       `json:"local_size,omitempty"`    // 本地一级缓存的最大条数
+	LocalTtl     int64     `json:"local_ttl,omitempty"`     // 本地一级缓存的过期时间，单位s
+	KeyVersion   int       `json:"key_version,omitempty"`   // 缓存key的版本，entity结构变化时加1让旧数据自然过期
+	Namespace    string    `json:"namespace,omitempty"`     // 缓存key的前缀
+	SkipCheck    bool      `json:"-"`                       // 跳过 pb entity、dao 和 go2cache 的存在性校验
+	Test         bool      `json:"test,omitempty"`          // 同时生成 <table>_codec_test.go
+	List         string    `json:"list,omitempty"`          // 非唯一列，生成该列到唯一键列表的缓存，如 room_id
+	Encoding     string    `json:"encoding,omitempty"`      // 缓存数据的序列化格式: protobuf,json,msgpack
+	Layout       string    `json:"layout,omitempty"`        // 缓存数据在redis中的结构: string,hash
+	Metrics      bool      `json:"metrics,omitempty"`       // 记录命中、回源条数和回源耗时
+	WarmUp       bool      `json:"warm_up,omitempty"`       // 同时生成 <table>_codec_warmup.go 和预热的 cmd 入口
+	ShardCount   int       `json:"shard_count,omitempty"`   // 分表数，表名为 <table>_00..<table>_<ShardCount-1>，0 表示不分表
+	ShardFunc    string    `json:"shard_func,omitempty"`    // 根据唯一键计算分表的方式: mod,crc32
+	ShardWidth   int       `json:"shard_width,omitempty"`   // 分表后缀的位数，0 表示按分表数自动计算
+	Chunk        int       `json:"chunk,omitempty"`         // FindAll 每次db查询最多的key数，0 表示不分批
+	LoadTimeout  int64     `json:"load_timeout,omitempty"`  // 每次回源db的超时时间，单位ms，0 表示只使用调用方的ctx
+	SingleFlight bool      `json:"single_flight,omitempty"` // 合并同一个key并发的回源
+	explicit     fieldSet  // 声明文件中写了的字段，包括写成零值的，填充默认值时保留
+}
+
+// DefaultNotFoundTtl 不存在记录的默认缓存时间，单位s
+const DefaultNotFoundTtl = 60
+
+// notFoundSuffix -notfound 时不存在记录的标记key，在codec的key后追加。模板通过 codecData.NotFoundMark 使用，lint 按它计算标记key
+const notFoundSuffix = ".nil"
+
+const (
+	EncodingProtobuf = "protobuf" // pb.Entity<PbName>，通过 go2cache 读写
+	EncodingJSON     = "json"     // model.<PbName>，encoding/json
+	EncodingMsgpack  = "msgpack"  // model.<PbName>，github.com/vmihailenco/msgpack/v5
+)
+
+const (
+	LayoutString = "string" // 每条记录一个序列化后的string
+	LayoutHash   = "hash"   // 每条记录一个hash，每列一个field，可以用 HMGET 读取部分列
+)
+
+const (
+	TierRedis = "redis" // 只使用redis
+	TierLocal = "local" // 进程内LRU + redis
+
+	DefaultLocalSize = 1000 // 本地一级缓存的默认条数
+	DefaultLocalTtl  = 60   // 本地一级缓存的默认过期时间，单位s
+)
+
+// codecData 渲染codec模板的数据
+type codecData struct {
+	Package      string
+	Module       string
+	Table        string // 表名，也是 TableCodecMap 的key
+	PbName       string // pb.Entity<PbName> 和 dao.<PbName>
+	LowerName    string
+	KeyColumn    string // 唯一键的列名
+	KeyField     string // 唯一键在 pb entity 中的字段名
+	KeyFormat    string // 缓存key的格式
+	RedisDb      string // library 中的redis变量名
+	TtlSeconds   int64
+	Query        string // 唯一键条件之后的查询条件和列裁剪，如 .Where("deleted = 0").FieldsEx("description")
+	Wheres       string // Query 中的查询条件部分
+	NotFound     bool
+	NotFoundTtl  int64
+	NotFoundMark string // 不存在标记在key后追加的后缀
+	TtlFunc      bool   // 需要 go2cache.WithTtlFunc
+	Jitter       int
+	TtlHook      bool
+	Local        bool
+	LocalSize    int
+	LocalTtl     int64
+	ListColumn   string // -list 的列名
+	ListName     string // <PbName>By<ListColumn>，列表codec的类型和函数名
+	Encoding     string // json 或 msgpack 时缓存 model.<PbName>，为空时缓存 pb.Entity<PbName>
+	Hash         bool   // -layout hash
+	Metrics      bool
+	MetricsLabel string   // 监控指标的 table 标签，列表codec为 <table>.<list>
+	CodecPath    string   // codec 包的import路径，预热的 cmd 入口使用
+	Shards       []string // 分表的后缀，不分表时为空
+	ShardCount   int
+	ShardCrc32   bool
+	Chunk        int
+	LoadTimeout  int64
+	SingleFlight bool
+	OneFunc      string     // 查询db的 One 方法名，外面包装了 singleflight 或监控时改为小写，也是所在区域的名字
+	FindAllFunc  string     // 查询db的 FindAll 方法名，外面包装了 singleflight 或监控时改为小写，也是所在区域的名字
+	FindResult   string     // -metrics 生成的 Find<PbName> 的结果类型，与 go2cache 的 Server.Find 一致
+	TestRedis    *testRedis // -test 时替换 library 中redis连接的方式，为空时需要手动实现 useTestRedis
+	TestColumns  []string   // -test 时 mock db 返回的表结构，gdb 第一次查询表前会读取
+}
+
+func CodecExec(cfg Config) error {
+	if err := keepWarmUp(&cfg); err != nil {
+		return err
+	}
+	staleTest, err := keepTest(&cfg)
+	if err != nil {
+		return err
+	}
+	data, err := newCodecData(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = DefaultOutputDir
+	}
+	dir := cfg.OutputDir
+	files := []outputFile{
+		{filepath.Join(dir, supportFile), supportTemplate, OverwriteForce},
+		{filepath.Join(dir, data.Table+"_codec.go"), codecTemplate, cfg.Overwrite},
+	}
+	if data.ListColumn != "" {
+		files[1].path = filepath.Join(dir, data.Table+"_"+data.ListColumn+"_list_codec.go")
+		files[1].tmpl = listTemplate
+	} else if data.Hash {
+		files[1].tmpl = hashTemplate
+	} else if data.Encoding != "" {
+		files[1].tmpl = structTemplate
+	}
+	if cfg.Test {
+		files = append(files,
+			outputFile{filepath.Join(dir, data.Table+"_codec_test.go"), testTemplate, cfg.Overwrite},
+			// 公用环境中的 useTestRedis 可能需要手动实现，合并时保留手动修改的区域
+			outputFile{filepath.Join(dir, testEnvFile), testEnvTemplate, OverwriteMerge},
+		)
+	}
+	if cfg.WarmUp {
+		if data.ListColumn != "" {
+			return fmt.Errorf("-list 不支持 -warmup")
+		}
+		cmd, err := warmUpCmdFile(dir, data)
+		if err != nil {
+			return err
+		}
+		files = append(files, outputFile{filepath.Join(dir, data.Table+warmUpSuffix), warmUpTemplate, cfg.Overwrite}, cmd)
+	}
+	if err = writeFiles(files, data); err != nil {
+		return err
+	}
+	if staleTest != "" {
+		return removeStale(staleTest)
+	}
+	return nil
+}
+
+// keepTest 之前用 -test 生成的测试调用了codec的函数，存在时跟着重新生成；
+// 新的参数不支持 -test 时返回测试文件的路径，写入新的codec后备份并删除，避免测试文件无法编译
+func keepTest(cfg *Config) (string, error) {
+	if cfg.Test || cfg.List != "" {
+		return "", nil
+	}
+	dir := cfg.OutputDir
+	if dir == "" {
+		dir = DefaultOutputDir
+	}
+	// 手写的测试文件不是按codec的模板生成的，不重新生成也不删除
+	path := filepath.Join(dir, cfg.TableName+"_codec_test.go")
+	b, err := generatedTest(path)
+	if err != nil || !b {
+		return "", err
+	}
+	if cfg.testSupported() {
+		fmt.Printf("已存在测试文件 %s，同时重新生成\n", path)
+		cfg.Test = true
+		return "", nil
+	}
+	return path, nil
+}
+
+// testSupported -test 的模板只支持不分表的 protobuf string codec
+func (cfg Config) testSupported() bool {
+	protobuf := cfg.Encoding == "" || cfg.Encoding == EncodingProtobuf
+	str := cfg.Layout == "" || cfg.Layout == LayoutString
+	return protobuf && str && cfg.ShardCount == 0 && cfg.List == ""
+}
+
+// removeStale 备份并删除无法再生成的文件
+func removeStale(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取已存在的文件 %s 失败: %v", path, err)
+	}
+	backup, err := backupFile(path, data)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("删除文件 %s 失败: %v", path, err)
+	}
+	fmt.Printf("警告: 新的参数不支持 -test，已删除测试文件 %s，原文件备份到 %s\n", path, backup)
+	return nil
+}
+
+// outputFile 一次生成中要写入的文件
+type outputFile struct {
+	path   string
+	tmpl   *template.Template
+	policy Overwrite
+}
+
+// writeFiles 先渲染所有文件并按覆盖策略处理已存在的文件，都没有冲突后再一起写入，
+// 避免其中一个文件合并失败时其他文件已经被覆盖
+func writeFiles(files []outputFile, data interface{}) error {
+	contents := make([][]byte, len(files))
+	for i, f := range files {
+		content, err := render(f.tmpl, data)
+		if err != nil {
+			return err
+		}
+		if contents[i], err = resolve(f.path, content, f.policy); err != nil {
+			return err
+		}
+	}
+	for i, f := range files {
+		if contents[i] == nil {
+			continue
+		}
+		if err := write(f.path, contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCodecData 校验参数并生成模板数据
+func newCodecData(cfg Config) (*codecData, error) {
+	if cfg.TableName == "" {
+		return nil, fmt.Errorf("必须输入-t参数，db表名的意思")
+	}
+
+	if cfg.Seconds <= 0 && cfg.Hours <= 0 {
+		return nil, fmt.Errorf("必须输入-s或者-h参数，优先级-s > -h，指定过期时间")
+	}
+	seconds := cfg.ttlSeconds()
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = DefaultOutputDir
+	}
+	pkg := cfg.Package
+	if pkg == "" {
+		derived, err := PackageFromDir(cfg.OutputDir)
+		if err != nil {
+			return nil, err
+		}
+		pkg = derived
+	} else if !isIdentifier(pkg) {
+		return nil, fmt.Errorf("-pkg %q 不是合法的go包名", pkg)
+	}
+
+	err := checkRedisDb(cfg.RedisDb)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.List != "" {
+		return newListData(cfg, pkg, seconds)
+	}
+
+	tableName := cfg.TableName
 	pbName := FirstUppers(tableName)
 
-	redisDb := fmt.Sprintf("%s%s", "Redis", FirstUppers(*d))
-	ttt := strings.Replace(templateV2, "%pbName", pbName, 1000)
-	ttt = strings.Replace(ttt, "%lowerName", FirstLower(pbName), 1000)
-	ttt = strings.Replace(ttt, "%mode", *mode, 1000)
-	ttt = strings.Replace(ttt, "%tableName", pbName+"TableKey", 1000)
-	ttt = strings.Replace(ttt, "%tableKey", tableName, 1000)
-	ttt = strings.Replace(ttt, "%ttl", fmt.Sprintf("%d", seconds), 1000)
-	ttt = strings.Replace(ttt, "%redis-db", redisDb, 100)
-	ttt = strings.Replace(ttt, "%entity_id", FirstUpper(*primaryAlisaName), 100)
-	ttt = strings.Replace(ttt, "%id", strings.ToLower(*primaryAlisaName), 100)
-	generate(tableName, ttt)
+	encoding, err := checkEncoding(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := checkLayout(cfg)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := checkShards(cfg)
+	if err != nil {
+		return nil, err
+	}
+	key := EntityKey{Field: FirstUppers(strings.ToLower(cfg.UniqueKey))}
+	if !cfg.SkipCheck {
+		if hash {
+			err = verifyDao(pbName)
+		} else if encoding != "" {
+			err = verifyModel(pbName, cfg.UniqueKey)
+		} else if len(shards) > 0 {
+			if key, err = verifyEntity(pbName, cfg.UniqueKey); err == nil {
+				daoNames := make([]string, len(shards))
+				for i, suffix := range shards {
+					daoNames[i] = pbName + suffix
+				}
+				err = verifyDao(daoNames...)
+			}
+		} else {
+			key, err = VerifyProject(pbName, cfg.UniqueKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 分表的结构相同，读取第一个分表
+	schemaTable := tableName
+	if len(shards) > 0 {
+		schemaTable = tableName + "_" + shards[0]
+	}
+	schema, err := LoadSchema(schemaTable)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := projection(cfg, schema)
+	if err != nil {
+		return nil, err
+	}
+	wheres, err := conditions(cfg, schema)
+	if err != nil {
+		return nil, err
+	}
+	keyFormat, err := KeyFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &codecData{
+		Package:      pkg,
+		Module:       cfg.Module,
+		Table:        tableName,
+		PbName:       pbName,
+		LowerName:    FirstLower(pbName),
+		KeyColumn:    strings.ToLower(cfg.UniqueKey),
+		KeyField:     key.Field,
+		KeyFormat:    keyFormat,
+		RedisDb:      "Redis" + FirstUppers(cfg.RedisDb),
+		TtlSeconds:   seconds,
+		Query:        wheres + fields,
+		Wheres:       wheres,
+		NotFound:     cfg.NotFound,
+		NotFoundMark: notFoundSuffix,
+		Jitter:       cfg.Jitter,
+		TtlHook:      cfg.TtlHook,
+		Encoding:     encoding,
+		Hash:         hash,
+		Shards:       shards,
+		ShardCount:   cfg.ShardCount,
+		ShardCrc32:   cfg.ShardFunc == ShardCrc32,
+		Chunk:        cfg.Chunk,
+		LoadTimeout:  cfg.LoadTimeout,
+		SingleFlight: cfg.SingleFlight,
+		OneFunc:      "One",
+		FindAllFunc:  "FindAll",
+		Metrics:      cfg.Metrics,
+		MetricsLabel: tableName,
+	}
+	if data.NotFound {
+		data.NotFoundTtl = cfg.NotFoundTtl
+		if data.NotFoundTtl <= 0 {
+			data.NotFoundTtl = DefaultNotFoundTtl
+		}
+	}
+
+	if err = checkGuards(cfg, encoding != "" || hash); err != nil {
+		return nil, err
+	}
+	if data.SingleFlight {
+		data.OneFunc = "loadOne"
+	} else if data.Metrics {
+		data.OneFunc = "one"
+	}
+	if data.SingleFlight {
+		data.FindAllFunc = "loadFindAll"
+	} else if data.Metrics {
+		data.FindAllFunc = "findAll"
+	}
+
+	if cfg.Jitter < 0 || cfg.Jitter > 100 {
+		return nil, fmt.Errorf("-jitter 必须在 0~100 之间")
+	}
+	data.TtlFunc = cfg.Jitter > 0 || cfg.TtlHook
+
+	switch cfg.Tier {
+	case "", TierRedis:
+	case TierLocal:
+		data.Local = true
+		data.LocalSize, data.LocalTtl = cfg.LocalSize, cfg.LocalTtl
+		if data.LocalSize <= 0 {
+			data.LocalSize = DefaultLocalSize
+		}
+		if data.LocalTtl <= 0 {
+			data.LocalTtl = DefaultLocalTtl
+		}
+	default:
+		return nil, fmt.Errorf("未知的 -tier %q，可选 redis,local", cfg.Tier)
+	}
+	if !cfg.SkipCheck && encoding == "" && !hash {
+		if err = verifyGo2cache(go2cacheFuncs(cfg, data)); err != nil {
+			return nil, err
+		}
+	}
+	if data.Metrics && encoding == "" && !hash {
+		data.FindResult = defaultFindResult
+		if !cfg.SkipCheck {
+			if data.FindResult, err = findResult(Go2cacheDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.Test {
+		if data.TestRedis, err = goRedisClients(LibraryDir); err != nil {
+			return nil, err
+		}
+		// 公用环境替换 library 中所有 go-redis 连接，和具体的codec无关
+		if data.TestRedis == nil || !containsString(data.TestRedis.Vars, data.RedisDb) {
+			fmt.Printf("警告: library.%s 不是 go-redis 的 *redis.Client，需要在 %s 中手动实现 useTestRedis\n", data.RedisDb, testEnvFile)
+		}
+		data.TestColumns = []string{data.KeyColumn}
+		if schema != nil {
+			data.TestColumns = schema.Columns
+		}
+	}
+	return data, nil
+}
+
+// checkEncoding 校验 -encoding，protobuf 返回空。json/msgpack 的codec不经过 go2cache，只支持 redis 缓存和过期时间抖动
+func checkEncoding(cfg Config) (string, error) {
+	switch cfg.Encoding {
+	case "", EncodingProtobuf:
+		return "", nil
+	case EncodingJSON, EncodingMsgpack:
+	default:
+		return "", fmt.Errorf("未知的 -encoding %q，可选 protobuf,json,msgpack", cfg.Encoding)
+	}
+	switch {
+	case cfg.NotFound:
+		return "", fmt.Errorf("-encoding %s 不支持 -notfound", cfg.Encoding)
+	case cfg.TtlHook:
+		return "", fmt.Errorf("-encoding %s 不支持 -ttl-hook", cfg.Encoding)
+	case cfg.Tier != "" && cfg.Tier != TierRedis:
+		return "", fmt.Errorf("-encoding %s 只支持 -tier redis", cfg.Encoding)
+	case cfg.Test:
+		return "", fmt.Errorf("-encoding %s 暂不支持 -test", cfg.Encoding)
+	}
+	return cfg.Encoding, nil
+}
+
+// checkLayout 校验 -layout，hash 结构按列缓存，不经过 go2cache，也不需要序列化
+func checkLayout(cfg Config) (bool, error) {
+	switch cfg.Layout {
+	case "", LayoutString:
+		return false, nil
+	case LayoutHash:
+	default:
+		return false, fmt.Errorf("未知的 -layout %q，可选 string,hash", cfg.Layout)
+	}
+	switch {
+	case cfg.Encoding != "" && cfg.Encoding != EncodingProtobuf:
+		return false, fmt.Errorf("-layout hash 按列保存字符串，不需要 -encoding")
+	case cfg.NotFound:
+		return false, fmt.Errorf("-layout hash 不支持 -notfound")
+	case cfg.TtlHook:
+		return false, fmt.Errorf("-layout hash 不支持 -ttl-hook")
+	case cfg.Tier != "" && cfg.Tier != TierRedis:
+		return false, fmt.Errorf("-layout hash 只支持 -tier redis")
+	case cfg.Test:
+		return false, fmt.Errorf("-layout hash 暂不支持 -test")
+	case len(cfg.Fields) > 0 || len(cfg.Exclude) > 0:
+		// 缓存中只有裁剪后的列时，读取其他列的 HMGET 永远不会命中
+		return false, fmt.Errorf("-layout hash 不支持 -fields/-exclude，读取时传入 fields 即可只返回部分列")
+	}
+	return true, nil
+}
+
+// checkGuards 校验回源的分批、超时和 singleflight 参数，只有 protobuf 的codec支持
+func checkGuards(cfg Config, custom bool) error {
+	if cfg.Chunk < 0 || cfg.LoadTimeout < 0 {
+		return fmt.Errorf("-chunk 和 -load-timeout 不能小于0")
+	}
+	if custom && (cfg.Chunk > 0 || cfg.LoadTimeout > 0 || cfg.SingleFlight) {
+		return fmt.Errorf("-chunk/-load-timeout/-singleflight 只支持 -encoding protobuf -layout string 的codec")
+	}
+	return nil
+}
+
+// render 执行模板并用 go/format 格式化，生成的代码无法解析时返回带行号的源码方便定位
+func render(tmpl *template.Template, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("执行%s模板失败: %v", tmpl.Name(), err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s模板生成的代码无法解析: %v\n%s", tmpl.Name(), err, numberLines(buf.String()))
+	}
+	return src, nil
+}
+
+func numberLines(src string) string {
+	var b strings.Builder
+	for i, line := range strings.Split(src, "\n") {
+		fmt.Fprintf(&b, "%4d  %s\n", i+1, line)
+	}
+	return b.String()
+}
+
+const (
+	supportFile = "codec_support.go"      // codec 包公用代码的文件名
+	testEnvFile = "codec_testenv_test.go" // codec 测试公用环境的文件名
+)
+
+// ttlSeconds 缓存过期时间，-s 优先于 -h
+func (cfg Config) ttlSeconds() int64 {
+	if cfg.Seconds > 0 {
+		return cfg.Seconds
+	}
+	return cfg.Hours * 60 * 60
+}
+
+// KeyFormat 缓存key的格式: [namespace.]table.key.<table>.[v<version>.]<uq>.%d，
+// 列表codec为 [namespace.]table.list.<table>.[v<version>.]<list>.%d
+func KeyFormat(cfg Config) (string, error) {
+	if cfg.KeyVersion < 0 {
+		return "", fmt.Errorf("-key-version 不能小于0")
+	}
+	if strings.ContainsAny(cfg.Namespace, "% \t\"") {
+		return "", fmt.Errorf("-ns %q 不能包含空白、引号或%%", cfg.Namespace)
+	}
+	var b strings.Builder
+	if ns := strings.Trim(cfg.Namespace, "."); ns != "" {
+		b.WriteString(ns + ".")
+	}
+	kind, column := "key", cfg.UniqueKey
+	if cfg.List != "" {
+		kind, column = "list", cfg.List
+	}
+	b.WriteString("table." + kind + "." + cfg.TableName + ".")
+	if cfg.KeyVersion > 0 {
+		fmt.Fprintf(&b, "v%d.", cfg.KeyVersion)
+	}
+	b.WriteString(strings.ToLower(column) + ".%d")
+	return b.String(), nil
+}
+
+// PackageFromDir 根据输出目录推导包名，规则与 go 的目录名习惯一致
+func PackageFromDir(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("解析输出目录失败: %v", err)
+	}
+	name := strings.ToLower(filepath.Base(absDir))
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+	if !isIdentifier(name) {
+		return "", fmt.Errorf("无法根据目录 %s 推导包名，请使用-pkg指定", dir)
+	}
+	return name, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
 }
 
 // FirstUpper 字符串首字母大写
@@ -80,46 +625,43 @@ func FirstLower(s string) string {
 
 }
 
-func generate(filename string, context string) {
-	path := "codec"
-	//创建一个新文件，写入内容 5 句 "http://c.biancheng.net/golang/"
-	filePath := fmt.Sprintf("./rpc/server/internal/cache/%s/%s_codec.go", path, filename)
-	b, err := PathExists(filePath)
+// resolve 给生成的内容加上区域标记并按覆盖策略处理已存在的文件，返回 nil 表示不写入
+func resolve(filePath string, content []byte, policy Overwrite) ([]byte, error) {
+	sealed, err := sealRegions(string(content))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	absPath, err := filepath.Abs(filePath)
-	if err != nil {
-		panic(err)
+	sealed, ok, err := resolveOverwrite(policy, filePath, sealed)
+	if err != nil || !ok {
+		return nil, err
 	}
-	fmt.Println(fmt.Sprintf("生成文件的路径:%s", absPath))
-
-	if b {
-		if err = os.Remove(filePath); err != nil {
-			panic(err)
-		}
+	// 合并进来的旧区域可能改变缩进，重新格式化一次
+	src, err := format.Source([]byte(sealed))
+	if err != nil {
+		return nil, fmt.Errorf("合并后的 %s 无法解析: %v", filePath, err)
 	}
-
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+	sealed, err = sealOutside(string(src))
 	if err != nil {
-		fmt.Println("文件打开失败", err)
+		return nil, err
 	}
+	return []byte(sealed), nil
+}
 
-	//及时关闭file句柄
-	defer file.Close()
-	//写入文件时，使用带缓存的 *Writer
-	write := bufio.NewWriter(file)
-	write.WriteString(context)
-	//Flush将缓存的文件真正写入到文件中
-	write.Flush()
-
-	msg, err := RunCommand("./", "gofmt", "-l", "-w", absPath)
+func write(filePath string, content []byte) error {
+	absPath, err := filepath.Abs(filePath)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Println(fmt.Sprintf("success. 缓存code路径：%s  msg=%s", filePath, msg))
-
+	if dir := filepath.Dir(filePath); dir != "" {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+		}
+	}
+	if err = os.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("写入文件 %s 失败: %v", filePath, err)
+	}
+	fmt.Printf("生成文件: %s\n", absPath)
+	return nil
 }
 
 func PathExists(path string) (bool, error) {
@@ -132,14 +674,3 @@ func PathExists(path string) (bool, error) {
 	}
 	return false, err
 }
-
-func RunCommand(path, name string, arg ...string) (msg string, err error) {
-	cmd := exec.Command(name, arg...)
-	cmd.Dir = path
-	err = cmd.Run()
-	log.Println(cmd.Args)
-	if err != nil {
-		log.Println("err", err.Error(), "cmd", cmd.Args)
-	}
-	return
-}
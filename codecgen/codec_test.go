@@ -0,0 +1,602 @@
+package codecgen
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// generateCodec 在临时的项目目录中按 edit 修改后的参数生成codec，返回生成的文件内容，key 为文件名。
+// -warmup 需要相对路径，所以生成时切换到项目目录
+func generateCodec(t *testing.T, edit func(cfg *Config)) (map[string]string, error) {
+	t.Helper()
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(root) })
+	cfg := testCodecConfig("codec")
+	edit(&cfg)
+	if err = CodecExec(cfg); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(cfg.OutputDir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(path)] = string(content)
+	}
+	return files, nil
+}
+
+// generatedCase 生成参数和生成的文件中应该有、不应该有的代码，wantErr 不为空时生成应该失败
+type generatedCase struct {
+	name     string
+	edit     func(cfg *Config)
+	file     string
+	contains []string
+	excludes []string
+	wantErr  string
+}
+
+func runGeneratedCases(t *testing.T, tests []generatedCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := generateCodec(t, tt.edit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CodecExec() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := tt.file
+			if file == "" {
+				file = "user_info_codec.go"
+			}
+			got, ok := files[file]
+			if !ok {
+				t.Fatalf("%s not generated", file)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Errorf("%s does not contain %q", file, s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(got, s) {
+					t.Errorf("%s contains %q", file, s)
+				}
+			}
+		})
+	}
+}
+
+func TestShardedCodec(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name:     "mod",
+			edit:     func(cfg *Config) { cfg.ShardCount = 4 },
+			contains: []string{"return int(key % 4)", "dao.UserInfo0.Ctx(ctx)", "m = dao.UserInfo3.Ctx(ctx)", "userInfoGroupByShard(keys)"},
+			excludes: []string{`"hash/crc32"`, `"strconv"`, "dao.UserInfo.Ctx"},
+		},
+		{
+			name:     "crc32",
+			edit:     func(cfg *Config) { cfg.ShardCount, cfg.ShardFunc = 16, ShardCrc32 },
+			contains: []string{"\t\"hash/crc32\"\n\t\"strconv\"\n", "crc32.ChecksumIEEE([]byte(strconv.FormatUint(uint64(key), 10))) % 16", "m = dao.UserInfo15.Ctx(ctx)"},
+		},
+		{
+			name:     "width",
+			edit:     func(cfg *Config) { cfg.ShardCount, cfg.ShardWidth = 10, 3 },
+			contains: []string{"dao.UserInfo000.Ctx(ctx)", "m = dao.UserInfo009.Ctx(ctx)"},
+		},
+		{
+			name:    "width too small",
+			edit:    func(cfg *Config) { cfg.ShardCount, cfg.ShardWidth = 100, 1 },
+			wantErr: "-shard-width 1 不足以表示 100 个分表",
+		},
+		{
+			name:    "hash layout",
+			edit:    func(cfg *Config) { cfg.ShardCount, cfg.Layout = 4, LayoutHash },
+			wantErr: "-shards 不支持 -layout hash",
+		},
+	})
+}
+
+func TestListCodec(t *testing.T) {
+	list := func(cfg *Config) { cfg.UniqueKey, cfg.List = "id", "room_id" }
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "list",
+			edit: list,
+			file: "user_info_room_id_list_codec.go",
+			contains: []string{
+				"UserInfoByRoomIdListCodec = userInfoByRoomIdCodec{}",
+				`ListCodecMap["user_info.room_id"]`,
+				"if key == 0 {\n\t\treturn \"\"\n\t}\n\treturn fmt.Sprintf(tableKeyUserInfoByRoomId, key)",
+				`Fields("room_id,id").Where("room_id in (?)", keys)`,
+				"missed = append(missed, valid[i])",
+			},
+			excludes: []string{"cacheKeys[i] = b.Key(key)"},
+		},
+		{
+			name:     "support",
+			edit:     list,
+			file:     supportFile,
+			contains: []string{"type ListCodec interface", "func EncodeIds(", "func DecodeIds("},
+		},
+		{
+			name:    "shards",
+			edit:    func(cfg *Config) { list(cfg); cfg.ShardCount = 4 },
+			wantErr: "-list",
+		},
+		{
+			name:    "list is the unique key",
+			edit:    func(cfg *Config) { cfg.UniqueKey, cfg.List = "id", "id" },
+			wantErr: "-list",
+		},
+	})
+}
+
+func TestHashCodec(t *testing.T) {
+	hash := func(cfg *Config) { cfg.Layout = LayoutHash }
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "hash",
+			edit: hash,
+			contains: []string{
+				"func GetUserInfoFields(ctx context.Context, key uint32, fields ...string) (map[string]string, error)",
+				"func GetUserInfoFieldsMulti(ctx context.Context, keys []uint32, fields ...string) (map[uint32]map[string]string, error)",
+				"pipe := library.RedisUser.Pipeline()",
+				"pipe.HGetAll(ctx, b.Key(key)).Result",
+				"pipe.HMGet(ctx, b.Key(key), fields...).Result",
+				"pipe.HSet(ctx, cacheKey, values)",
+				`EntityCodecMap["user_info"]`,
+			},
+			excludes: []string{"go2cache.", "proto.Message"},
+		},
+		{
+			name:    "fields",
+			edit:    func(cfg *Config) { hash(cfg); cfg.Fields = []string{"name"} },
+			wantErr: "-layout hash 不支持 -fields/-exclude",
+		},
+		{
+			name:    "exclude",
+			edit:    func(cfg *Config) { hash(cfg); cfg.Exclude = []string{"description"} },
+			wantErr: "-layout hash 不支持 -fields/-exclude",
+		},
+		{
+			name:    "encoding",
+			edit:    func(cfg *Config) { hash(cfg); cfg.Encoding = EncodingJSON },
+			wantErr: "-layout hash 按列保存字符串",
+		},
+		{
+			name:    "notfound",
+			edit:    func(cfg *Config) { hash(cfg); cfg.NotFound = true },
+			wantErr: "-layout hash 不支持 -notfound",
+		},
+	})
+}
+
+func TestCodecTests(t *testing.T) {
+	test := func(cfg *Config) { cfg.Test = true }
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "test",
+			edit: test,
+			file: "user_info_codec_test.go",
+			contains: []string{
+				`codec.Key(10086), "table.key.user_info.uid.10086"`,
+				"dao.UserInfo.M = env.DB.Model(\"user_info\").Safe()",
+				// 没有表结构时只返回唯一键
+				`env.ExpectTableFields("uid")`,
+				"env := newCodecTestEnv(t, true)\n\tuseUserInfoTestDB(t, env)",
+				`ExpectQuery("SELECT .+ FROM .*user_info")`,
+			},
+			excludes: []string{"TestUserInfoCodecNotFound"},
+		},
+		{
+			name:     "notfound",
+			edit:     func(cfg *Config) { test(cfg); cfg.NotFound = true },
+			file:     "user_info_codec_test.go",
+			contains: []string{"func TestUserInfoCodecNotFound", `env.Redis.Set("table.key.user_info.uid.10086.nil", "1")`, `"table.key.user_info.uid.3.nil"`},
+		},
+		{
+			name: "env",
+			edit: test,
+			file: testEnvFile,
+			contains: []string{
+				"func (d *testDBDriver) Open(node *gdb.ConfigNode) (*sql.DB, error) {\n\treturn d.db, nil\n}",
+				"gdb.SetConfigGroup(group, gdb.ConfigGroup{{Type: group}})",
+				"env.Mock, env.DB = mock, useTestDB(t, db)",
+				`ExpectQuery("SHOW FULL COLUMNS FROM")`,
+			},
+			// 需要db的测试不能因为 useTestDB 未实现而跳过
+			excludes: []string{"useTestDB 未实现"},
+		},
+		{
+			name:    "hash",
+			edit:    func(cfg *Config) { test(cfg); cfg.Layout = LayoutHash },
+			wantErr: "-layout hash 暂不支持 -test",
+		},
+	})
+}
+
+func TestNotFoundCodec(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "notfound",
+			edit: func(cfg *Config) { cfg.NotFound = true },
+			contains: []string{
+				"notFoundTtlUserInfoSeconds = int64(60)",
+				"return b.Key(key) + " + `".nil"`,
+				`return &NotFoundError{Table: "user_info", Key: key}`,
+				"keys, err := b.skipNotFound(ctx, keys)",
+				"b.markNotFound(ctx, missing)",
+			},
+		},
+		{
+			name:     "ttl",
+			edit:     func(cfg *Config) { cfg.NotFound, cfg.NotFoundTtl = true, 300 },
+			contains: []string{"notFoundTtlUserInfoSeconds = int64(300)"},
+		},
+		{
+			name:     "without notfound",
+			edit:     func(cfg *Config) {},
+			excludes: []string{"notFoundKey", "NotFoundError", "notFoundTtl"},
+		},
+		{
+			name:     "support",
+			edit:     func(cfg *Config) { cfg.NotFound = true },
+			file:     supportFile,
+			contains: []string{"type NotFoundError struct", "var ErrNotFound"},
+		},
+		{
+			name:    "json",
+			edit:    func(cfg *Config) { cfg.NotFound, cfg.Encoding = true, EncodingJSON },
+			wantErr: "-notfound",
+		},
+	})
+}
+
+func TestTtlStrategies(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name:     "fixed",
+			edit:     func(cfg *Config) {},
+			contains: []string{"go2cache.WithTtl(expiredTime))"},
+			excludes: []string{"WithTtlFunc", "expiredJitter"},
+		},
+		{
+			name: "jitter",
+			edit: func(cfg *Config) { cfg.Jitter = 20 },
+			contains: []string{
+				"expiredJitterUserInfoPercent = 20",
+				"go2cache.WithTtlFunc(func(data proto.Message) time.Duration {\n\t\treturn JitterTtl(expiredTime, expiredJitterUserInfoPercent)",
+			},
+			excludes: []string{"TtlOf"},
+		},
+		{
+			name: "hook",
+			edit: func(cfg *Config) { cfg.TtlHook = true },
+			contains: []string{
+				"expiredJitterUserInfoPercent = 0",
+				"func (b userInfoCodec) TtlOf(data proto.Message) time.Duration",
+				"ttl := (userInfoCodec{}).TtlOf(data)",
+			},
+		},
+		{
+			name:     "json jitter",
+			edit:     func(cfg *Config) { cfg.Encoding, cfg.Jitter = EncodingJSON, 10 },
+			contains: []string{"return JitterTtl(expiredTime, expiredJitterUserInfoPercent)"},
+		},
+		{
+			name:    "jitter out of range",
+			edit:    func(cfg *Config) { cfg.Jitter = 101 },
+			wantErr: "-jitter 必须在 0~100 之间",
+		},
+		{
+			name:    "json hook",
+			edit:    func(cfg *Config) { cfg.Encoding, cfg.TtlHook = EncodingJSON, true },
+			wantErr: "-ttl-hook",
+		},
+	})
+}
+
+func TestInvalidationHooks(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "protobuf",
+			edit: func(cfg *Config) {},
+			contains: []string{
+				"func InvalidateUserInfo(ctx context.Context, keys ...uint32) error",
+				"cacheKeys = append(cacheKeys, codec.Key(key))",
+				`dao.UserInfo.Ctx(ctx).Data(data).Where("uid in (?)", keys).Update()`,
+				`dao.UserInfo.Ctx(ctx).Where("uid in (?)", keys).Delete()`,
+				"return InvalidateUserInfo(ctx, keys...)",
+			},
+			excludes: []string{"InvalidateUserInfoLocal"},
+		},
+		{
+			name:     "notfound",
+			edit:     func(cfg *Config) { cfg.NotFound = true },
+			contains: []string{"cacheKeys = append(cacheKeys, codec.Key(key), codec.notFoundKey(key))"},
+		},
+		{
+			name:     "local",
+			edit:     func(cfg *Config) { cfg.Tier = TierLocal },
+			contains: []string{"func InvalidateUserInfoLocal(keys ...uint32)", "\tInvalidateUserInfoLocal(keys...)\n\treturn library.RedisUser.Del"},
+		},
+		{
+			name:     "shards",
+			edit:     func(cfg *Config) { cfg.ShardCount = 2 },
+			contains: []string{"for shard, keys := range userInfoGroupByShard(keys) {", `m.Where("uid in (?)", keys).Delete()`},
+		},
+		{
+			name:     "hash",
+			edit:     func(cfg *Config) { cfg.Layout = LayoutHash },
+			contains: []string{"func (b userInfoCodec) Invalidate(ctx context.Context, keys ...uint32) error", "func InvalidateUserInfo(ctx context.Context, keys ...uint32) error"},
+		},
+	})
+}
+
+func TestKeyFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(cfg *Config)
+		want    string
+		wantErr string
+	}{
+		{name: "default", edit: func(cfg *Config) {}, want: "table.key.user_info.uid.%d"},
+		{name: "version", edit: func(cfg *Config) { cfg.KeyVersion = 2 }, want: "table.key.user_info.v2.uid.%d"},
+		{name: "namespace", edit: func(cfg *Config) { cfg.Namespace = ".slp." }, want: "slp.table.key.user_info.uid.%d"},
+		{name: "both", edit: func(cfg *Config) { cfg.Namespace, cfg.KeyVersion = "slp", 3 }, want: "slp.table.key.user_info.v3.uid.%d"},
+		{name: "list", edit: func(cfg *Config) { cfg.UniqueKey, cfg.List = "id", "Room_Id" }, want: "table.list.user_info.room_id.%d"},
+		{name: "upper key", edit: func(cfg *Config) { cfg.UniqueKey = "UID" }, want: "table.key.user_info.uid.%d"},
+		{name: "negative version", edit: func(cfg *Config) { cfg.KeyVersion = -1 }, wantErr: "-key-version 不能小于0"},
+		{name: "namespace with verb", edit: func(cfg *Config) { cfg.Namespace = "a%d" }, wantErr: "不能包含空白、引号或%"},
+		{name: "namespace with space", edit: func(cfg *Config) { cfg.Namespace = "a b" }, wantErr: "不能包含空白、引号或%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testCodecConfig("")
+			tt.edit(&cfg)
+			got, err := KeyFormat(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("KeyFormat() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("KeyFormat() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+	runGeneratedCases(t, []generatedCase{
+		{
+			name:     "generated",
+			edit:     func(cfg *Config) { cfg.Namespace, cfg.KeyVersion = "slp", 2 },
+			contains: []string{`tableKeyUserInfo = "slp.table.key.user_info.v2.uid.%d"`},
+		},
+	})
+}
+
+func TestRenderFormatted(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(cfg *Config)
+	}{
+		{"protobuf", func(cfg *Config) {}},
+		{"all protobuf options", func(cfg *Config) {
+			cfg.NotFound, cfg.Jitter, cfg.TtlHook, cfg.Tier, cfg.Metrics = true, 10, true, TierLocal, true
+			cfg.Chunk, cfg.LoadTimeout, cfg.SingleFlight = 100, 3, true
+		}},
+		{"test and warmup", func(cfg *Config) { cfg.Test, cfg.WarmUp, cfg.NotFound = true, true, true }},
+		{"json", func(cfg *Config) { cfg.Encoding, cfg.Metrics = EncodingJSON, true }},
+		{"msgpack", func(cfg *Config) { cfg.Encoding, cfg.Jitter = EncodingMsgpack, 5 }},
+		{"hash", func(cfg *Config) { cfg.Layout, cfg.Metrics = LayoutHash, true }},
+		{"shards", func(cfg *Config) { cfg.ShardCount, cfg.ShardFunc, cfg.Metrics = 8, ShardCrc32, true }},
+		{"list", func(cfg *Config) { cfg.UniqueKey, cfg.List, cfg.Jitter, cfg.Metrics = "id", "room_id", 10, true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := generateCodec(t, tt.edit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range files {
+				if !strings.HasPrefix(content, generatedHeader) && !strings.HasPrefix(content, "// Code generated by slpctl codec. DO NOT EDIT.") {
+					t.Errorf("%s has no generated header", name)
+				}
+				// 生成的文件已经格式化，区域标记不影响 gofmt 的结果
+				src, err := format.Source([]byte(content))
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+				if string(src) != content {
+					t.Errorf("%s is not gofmt-formatted", name)
+				}
+				if strings.Contains(content, "<no value>") {
+					t.Errorf("%s references a missing template field", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{"formatted", "package {{.}}\nfunc  f( )  {}\n", "package codec\n\nfunc f() {}\n", ""},
+		{"invalid", "package {{.}}\nfunc f( {\n", "", "   2  func f( {"},
+		{"missing field", "package {{.Name}}\n", "", "执行t模板失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(template.Must(template.New("t").Parse(tt.src)), "codec")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("render() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("render() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodedCodec(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "json",
+			edit: func(cfg *Config) { cfg.Encoding = EncodingJSON },
+			contains: []string{
+				"\t\"encoding/json\"\n",
+				`"slp/app/model"`,
+				`EntityCodecMap["user_info"] = UserInfoEntityCodec`,
+				"return json.Marshal(data)",
+				"json.Unmarshal(raw, data)",
+				"func GetUserInfoMulti(ctx context.Context, keys []uint32) (map[uint32]*model.UserInfo, error)",
+			},
+			excludes: []string{"msgpack", "go2cache", "proto.Message", "pb.Entity"},
+		},
+		{
+			name:     "msgpack",
+			edit:     func(cfg *Config) { cfg.Encoding = EncodingMsgpack },
+			contains: []string{`"github.com/vmihailenco/msgpack/v5"`, "return msgpack.Marshal(data)"},
+			excludes: []string{"encoding/json"},
+		},
+		{
+			name:     "protobuf",
+			edit:     func(cfg *Config) { cfg.Encoding = EncodingProtobuf },
+			contains: []string{"go2cache.NewOnlyRedisServer", "pb.EntityUserInfo"},
+			excludes: []string{"app/model"},
+		},
+		{
+			name:     "support",
+			edit:     func(cfg *Config) { cfg.Encoding = EncodingJSON },
+			file:     supportFile,
+			contains: []string{"type EntityCodec interface", "var EntityCodecMap"},
+		},
+		{
+			name:    "unknown",
+			edit:    func(cfg *Config) { cfg.Encoding = "gob" },
+			wantErr: `未知的 -encoding "gob"`,
+		},
+		{
+			name:    "local tier",
+			edit:    func(cfg *Config) { cfg.Encoding, cfg.Tier = EncodingMsgpack, TierLocal },
+			wantErr: "-tier",
+		},
+	})
+}
+
+func TestMetricsCodec(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "protobuf",
+			edit: func(cfg *Config) { cfg.Metrics = true },
+			contains: []string{
+				"func (b userInfoCodec) one(ctx context.Context, key uint32, data proto.Message) error",
+				"func (b userInfoCodec) findAll(ctx context.Context, keys []uint32, callback go2cache.Find2Item) error",
+				"countLoads(ctx, len(keys))",
+				`ObserveLoad("user_info", "One", 1, start, err)`,
+				`ObserveRead("user_info", 1, loads(), err)`,
+				// 没有 go2cache 目录时按默认的结果类型生成
+				"func FindUserInfo(ctx context.Context, keys []uint32) (map[uint32]proto.Message, error)",
+			},
+		},
+		{
+			// singleflight 包装在监控里面，监控的是真正查询db的次数
+			name:     "singleflight",
+			edit:     func(cfg *Config) { cfg.Metrics, cfg.SingleFlight = true, true },
+			contains: []string{"func (b userInfoCodec) loadOne(", "func (b userInfoCodec) one(", "err := b.one(ctx, key, data)"},
+		},
+		{
+			name:     "without metrics",
+			edit:     func(cfg *Config) {},
+			excludes: []string{"ObserveLoad", "ObserveRead", "countLoads", "func GetUserInfo("},
+		},
+		{
+			name:     "json",
+			edit:     func(cfg *Config) { cfg.Encoding, cfg.Metrics = EncodingJSON, true },
+			contains: []string{`ObserveHits("user_info", len(res))`, `ObserveLoad("user_info", "FindAll", len(missed), start, err)`},
+		},
+		{
+			name:     "list label",
+			edit:     func(cfg *Config) { cfg.UniqueKey, cfg.List, cfg.Metrics = "id", "room_id", true },
+			file:     "user_info_room_id_list_codec.go",
+			contains: []string{`ObserveHits("user_info.room_id", len(res))`},
+		},
+	})
+}
+
+func TestLoadGuards(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "timeout",
+			edit: func(cfg *Config) { cfg.LoadTimeout = 500 },
+			contains: []string{
+				"loadTimeoutUserInfoMillis = int64(500)",
+				"ctx, cancel := context.WithTimeout(ctx, time.Duration(loadTimeoutUserInfoMillis)*time.Millisecond)",
+			},
+			excludes: []string{"Chunks(", "KeyFlight"},
+		},
+		{
+			name:     "chunk",
+			edit:     func(cfg *Config) { cfg.Chunk = 200 },
+			contains: []string{"for _, keys := range Chunks(keys, 200) {"},
+			excludes: []string{"context.WithTimeout"},
+		},
+		{
+			name: "singleflight",
+			edit: func(cfg *Config) { cfg.SingleFlight = true },
+			contains: []string{
+				"return userInfoOneFlight.Load(ctx, []uint32{key}, ",
+				"return userInfoFindAllFlight.Load(ctx, keys, ",
+				"func (b userInfoCodec) loadOne(ctx context.Context, key uint32, data proto.Message) error",
+				"func (b userInfoCodec) loadFindAll(ctx context.Context, keys []uint32) (map[uint32]interface{}, error)",
+				"func (b userInfoCodec) One(ctx context.Context, key uint32, data proto.Message) error",
+			},
+		},
+		{
+			name:     "support",
+			edit:     func(cfg *Config) {},
+			file:     supportFile,
+			contains: []string{"func Chunks(keys []uint32, size int) [][]uint32", "type KeyFlight struct"},
+		},
+		{
+			name:    "negative chunk",
+			edit:    func(cfg *Config) { cfg.Chunk = -1 },
+			wantErr: "-chunk 和 -load-timeout 不能小于0",
+		},
+		{
+			name:    "json",
+			edit:    func(cfg *Config) { cfg.Encoding, cfg.SingleFlight = EncodingJSON, true },
+			wantErr: "-chunk/-load-timeout/-singleflight 只支持 -encoding protobuf -layout string 的codec",
+		},
+	})
+}
@@ -0,0 +1,301 @@
+package codecgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// Go2cacheDir 项目中 go2cache 包的目录
+var Go2cacheDir = filepath.Join(LibraryDir, "go2cache")
+
+// go2cacheFunc 生成的代码用到的 go2cache 函数
+type go2cacheFunc struct {
+	Name    string   // 函数名，*Server 的方法为 Server.<方法名>
+	Params  []string // 参数类型，为 nil 时不校验
+	Results []string // 返回值类型，为 nil 时不校验，* 匹配任意类型
+	Option  string   // 用到这个函数的参数，用于提示
+	// Implements 按参数的位置列出生成的代码传入的类型，参数需要是这些类型实现的 go2cache 接口
+	Implements map[int]implType
+}
+
+// implType 生成的代码传给 go2cache 的类型
+type implType struct {
+	Name    string            // 生成的类型，用于提示
+	Methods map[string]string // 方法名和签名，go2cache 包内的类型不带包名
+}
+
+// codecImpl 生成的 <table>Codec，作为 go2cache.Codec 传入
+var codecImpl = implType{Name: "codec", Methods: map[string]string{
+	"Pt":      "() proto.Message",
+	"Key":     "(uint32) string",
+	"Pk":      "(proto.Message) uint32",
+	"One":     "(context.Context, uint32, proto.Message) error",
+	"FindAll": "(context.Context, []uint32, Find2Item) error",
+}}
+
+// localCacheImpl -tier local 生成的 *LocalCache
+var localCacheImpl = implType{Name: "*LocalCache", Methods: map[string]string{
+	"Get": "(string) ([]byte, bool)",
+	"Set": "(string, []byte)",
+	"Del": "(...string)",
+}}
+
+// go2cacheFuncs 按生成参数列出用到的 go2cache 函数
+func go2cacheFuncs(cfg Config, data *codecData) []go2cacheFunc {
+	var funcs []go2cacheFunc
+	if data.Local {
+		funcs = append(funcs, go2cacheFunc{
+			Name:       "NewServer",
+			Params:     []string{"*", "*", "...Option"},
+			Results:    []string{"*Server"},
+			Option:     "-tier local",
+			Implements: map[int]implType{1: codecImpl},
+		}, go2cacheFunc{
+			Name:       "WithLocal",
+			Params:     []string{"*"},
+			Results:    []string{"Option"},
+			Option:     "-tier local",
+			Implements: map[int]implType{0: localCacheImpl},
+		})
+	}
+	if data.TtlFunc {
+		funcs = append(funcs, go2cacheFunc{
+			Name:   "WithTtlFunc",
+			Params: []string{"func(proto.Message) time.Duration"},
+			Option: "-jitter/-ttl-hook",
+		})
+	}
+	for _, read := range []struct {
+		on     bool
+		option string
+	}{{cfg.Test, "-test"}, {data.Metrics, "-metrics"}} {
+		if !read.on {
+			continue
+		}
+		funcs = append(funcs, go2cacheFunc{
+			Name:    "Server.Get",
+			Params:  []string{"context.Context", "uint32", "proto.Message"},
+			Results: []string{"error"},
+			Option:  read.option,
+		}, go2cacheFunc{
+			Name:    "Server.Find",
+			Params:  []string{"context.Context", "[]uint32"},
+			Results: []string{"*", "error"},
+			Option:  read.option,
+		})
+	}
+	if cfg.WarmUp {
+		funcs = append(funcs, go2cacheFunc{
+			Name:    "Server.Find",
+			Params:  []string{"context.Context", "[]uint32"},
+			Results: []string{"*", "error"},
+			Option:  "-warmup",
+		})
+	}
+	return funcs
+}
+
+// verifyGo2cache 确认 library/go2cache 导出了生成的代码用到的函数，找不到目录时只给出提示
+func verifyGo2cache(funcs []go2cacheFunc) error {
+	pkg, err := parseGo2cache(Go2cacheDir)
+	if err != nil {
+		return err
+	}
+	if pkg == nil {
+		fmt.Printf("警告: 没有找到 %s 目录，跳过 go2cache 的校验\n", Go2cacheDir)
+		return nil
+	}
+	for _, f := range funcs {
+		decl, ok := pkg.funcs[f.Name]
+		option := ""
+		if f.Option != "" {
+			option = f.Option + " "
+		}
+		if !ok {
+			return fmt.Errorf("%s 中没有定义 %s，%s生成的代码需要它，请先升级 go2cache", Go2cacheDir, f.Name, option)
+		}
+		params, results := fieldTypes(decl.Type.Params), fieldTypes(decl.Type.Results)
+		if (f.Params != nil && !equalStrings(params, f.Params)) || (f.Results != nil && !equalStrings(results, f.Results)) {
+			return fmt.Errorf("%s 中 %s 的签名是 %s，%s生成的代码需要 %s，请先升级 go2cache",
+				Go2cacheDir, f.Name, signature(params, results), option, signature(f.Params, f.Results))
+		}
+		for i, impl := range f.Implements {
+			if i >= len(params) {
+				continue
+			}
+			if err = pkg.implements(params[i], impl); err != nil {
+				return fmt.Errorf("%s 中 %s 的第 %d 个参数 %s，%s生成的代码无法传入，请先升级 go2cache", Go2cacheDir, f.Name, i+1, err, option)
+			}
+		}
+	}
+	return nil
+}
+
+// implements 确认 go2cache 中类型为 typ 的参数可以传入 impl，typ 需要是 impl 实现了全部方法的接口
+func (pkg *go2cachePkg) implements(typ string, impl implType) error {
+	if typ == "interface{}" {
+		return nil
+	}
+	iface, ok := pkg.interfaces[strings.TrimPrefix(typ, "...")]
+	if !ok {
+		return fmt.Errorf("%s 不是 go2cache 中的接口，不能接收 %s", typ, impl.Name)
+	}
+	for _, m := range iface.Methods.List {
+		fn, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) == 0 {
+			return fmt.Errorf("%s 嵌入了接口 %s，无法确认 %s 实现了它", typ, exprString(m.Type), impl.Name)
+		}
+		for _, name := range m.Names {
+			sig := signature(fieldTypes(fn.Params), fieldTypes(fn.Results))
+			if impl.Methods[name.Name] != sig {
+				return fmt.Errorf("%s 需要方法 %s%s，%s 没有实现", typ, name.Name, sig, impl.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultFindResult 没有找到 go2cache 目录时 Server.Find 的结果类型
+const defaultFindResult = "map[uint32]proto.Message"
+
+// findResult 读取 go2cache 中 Server.Find 的结果类型，生成的 Find<PbName> 原样返回。
+// go2cache 包内的类型加上 go2cache. 前缀，找不到目录或方法时返回 defaultFindResult
+func findResult(dir string) (string, error) {
+	pkg, err := parseGo2cache(dir)
+	if err != nil {
+		return "", err
+	}
+	if pkg == nil {
+		return defaultFindResult, nil
+	}
+	decl, ok := pkg.funcs["Server.Find"]
+	if !ok || decl.Type.Results == nil || len(decl.Type.Results.List) == 0 {
+		return defaultFindResult, nil
+	}
+	var unsupported string
+	result := decl.Type.Results.List[0].Type
+	ast.Inspect(result, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.SelectorExpr:
+			// codec 只导入了 proto，其他包的类型无法引用
+			if pkg := exprString(t.X); pkg != "proto" {
+				unsupported = pkg
+			}
+			return false
+		case *ast.Ident:
+			if t.IsExported() {
+				t.Name = "go2cache." + t.Name
+			}
+		}
+		return true
+	})
+	if unsupported != "" {
+		return "", fmt.Errorf("%s 中 Server.Find 的结果类型引用了包 %s，-metrics 生成的 Find<PbName> 只支持 proto 和 go2cache 包内的类型", dir, unsupported)
+	}
+	return exprString(result), nil
+}
+
+// go2cachePkg go2cache 包导出的函数和接口
+type go2cachePkg struct {
+	funcs      map[string]*ast.FuncDecl // 导出的函数和 *Server 的方法，方法的名字为 Server.<方法名>
+	interfaces map[string]*ast.InterfaceType
+}
+
+// parseGo2cache 解析 go2cache 包导出的函数和接口，目录不存在时返回 nil
+func parseGo2cache(dir string) (*go2cachePkg, error) {
+	b, err := PathExists(dir)
+	if err != nil || !b {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	pkg := &go2cachePkg{funcs: make(map[string]*ast.FuncDecl), interfaces: make(map[string]*ast.InterfaceType)}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				name := d.Name.Name
+				if d.Recv != nil {
+					recv := strings.TrimPrefix(exprString(d.Recv.List[0].Type), "*")
+					if recv != "Server" {
+						continue
+					}
+					name = recv + "." + name
+				}
+				pkg.funcs[name] = d
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || !ts.Name.IsExported() {
+						continue
+					}
+					if iface, ok := ts.Type.(*ast.InterfaceType); ok {
+						pkg.interfaces[ts.Name.Name] = iface
+					}
+				}
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// fieldTypes 参数或返回值的类型，多个名字共用一个类型时展开
+func fieldTypes(fields *ast.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	var types []string
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, exprString(field.Type))
+		}
+	}
+	return types
+}
+
+// signature 函数签名，如 (context.Context, uint32) error
+func signature(params, results []string) string {
+	s := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
+
+// equalStrings 比较实际的类型 a 和需要的类型 b
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && b[i] != "*" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// go2cacheSrc 项目中 go2cache 包支持 -tier local 的声明
+const go2cacheSrc = `package go2cache
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type Find2Item func(item interface{})
+
+type Codec interface {
+	Pt() proto.Message
+	Key(key uint32) string
+	Pk(data proto.Message) uint32
+	One(ctx context.Context, key uint32, data proto.Message) error
+	FindAll(ctx context.Context, keys []uint32, callback Find2Item) error
+}
+
+type Server struct{}
+type Option func(*Server)
+
+type Local interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Del(keys ...string)
+}
+
+func WithLocal(l Local) Option { return nil }
+func NewServer(client interface{}, c Codec, opts ...Option) *Server { return &Server{} }
+`
+
+func TestVerifyGo2cacheLocal(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace [2]string
+		wantErr string // 为空表示校验通过
+	}{
+		{"local interface", [2]string{}, ""},
+		{"empty interface", [2]string{"WithLocal(l Local)", "WithLocal(l interface{})"}, ""},
+		{"local not interface", [2]string{"WithLocal(l Local)", "WithLocal(l *Server)"}, "WithLocal 的第 1 个参数 *Server 不是 go2cache 中的接口"},
+		{"local method differs", [2]string{"Get(key string) ([]byte, bool)", "Get(key string) (interface{}, bool)"}, "Local 需要方法 Get(string) (interface{}, bool)，*LocalCache 没有实现"},
+		{"local extra method", [2]string{"Del(keys ...string)", "Del(keys ...string)\n\tLen() int"}, "Local 需要方法 Len() int"},
+		{"codec method differs", [2]string{"Pk(data proto.Message) uint32", "Pk(data proto.Message) uint64"}, "NewServer 的第 2 个参数 Codec 需要方法 Pk(proto.Message) uint64，codec 没有实现"},
+		{"codec embedded", [2]string{"Pt() proto.Message", "proto.Message"}, "Codec 嵌入了接口 proto.Message"},
+		{"server without options", [2]string{"c Codec, opts ...Option", "c Codec"}, "NewServer 的签名是 (interface{}, Codec) *Server"},
+	}
+	funcs := go2cacheFuncs(Config{}, &codecData{Local: true})
+	dir := Go2cacheDir
+	defer func() { Go2cacheDir = dir }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Go2cacheDir = t.TempDir()
+			src := go2cacheSrc
+			if tt.replace[0] != "" {
+				src = strings.Replace(src, tt.replace[0], tt.replace[1], 1)
+			}
+			if err := os.WriteFile(filepath.Join(Go2cacheDir, "go2cache.go"), []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := verifyGo2cache(funcs)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verifyGo2cache() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verifyGo2cache() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
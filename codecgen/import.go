@@ -0,0 +1,467 @@
+package codecgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// codecMethods go2cache 的codec需要实现的方法
+var codecMethods = []string{"Pt", "Key", "Pk", "One", "FindAll"}
+
+// timeUnits time 包中的时长常量，单位ns
+var timeUnits = map[string]int64{
+	"Nanosecond":  int64(time.Nanosecond),
+	"Microsecond": int64(time.Microsecond),
+	"Millisecond": int64(time.Millisecond),
+	"Second":      int64(time.Second),
+	"Minute":      int64(time.Minute),
+	"Hour":        int64(time.Hour),
+}
+
+// ImportedCodec 从手写的codec中识别出的参数
+type ImportedCodec struct {
+	Type      string   // codec 的类型名
+	KeyFormat string   // 原文件中的缓存key格式
+	Config    Config   // 重新生成时使用的参数
+	Issues    []string // 无法识别或重新生成后会变化的地方
+}
+
+// serverCall go2cache.NewOnlyRedisServer/NewServer 的调用，scope 是调用处可见的变量
+type serverCall struct {
+	call  *ast.CallExpr
+	scope map[string][]ast.Expr
+	table string
+}
+
+// ImportCodecs 解析手写的 go2cache codec 文件，识别实现了 Pt/Key/Pk/One/FindAll 的类型，
+// 从方法中读取entity、唯一键、key格式和查询条件，从创建 go2cache.Server 的调用中读取过期时间和 redis db，
+// 从 TableCodecMap 的注册中读取表名
+func ImportCodecs(path string) ([]*ImportedCodec, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	module := ""
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && strings.HasSuffix(p, "/app/dao") {
+			module = strings.TrimSuffix(p, "/app/dao")
+		}
+	}
+
+	// 包级别的常量和变量，以及每个类型的方法
+	values := make(map[string][]ast.Expr)
+	methods := make(map[string]map[string]*ast.FuncDecl)
+	var types []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			collectValues(d, values)
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) != 1 || d.Body == nil {
+				continue
+			}
+			name := recvType(d.Recv.List[0].Type)
+			if methods[name] == nil {
+				methods[name] = make(map[string]*ast.FuncDecl)
+				types = append(types, name)
+			}
+			methods[name][d.Name.Name] = d
+		}
+	}
+	servers := findServers(file, values)
+
+	var codecs []*ImportedCodec
+	for _, name := range types {
+		if !hasMethods(methods[name], codecMethods) {
+			continue
+		}
+		codec := importCodec(name, methods[name], values, servers[name])
+		codec.Config.Module = module
+		codec.Config.OutputDir = filepath.Dir(path)
+		codec.Config.Package = file.Name.Name
+		if module == "" {
+			codec.Issues = append(codec.Issues, "没有找到 app/dao 的import，需要在声明文件中补充 module")
+		}
+		codecs = append(codecs, codec)
+	}
+	if len(codecs) == 0 {
+		return nil, fmt.Errorf("%s 中没有实现 %s 的类型", path, strings.Join(codecMethods, "/"))
+	}
+	return codecs, nil
+}
+
+// importCodec 从一个codec类型的方法和创建它的 go2cache.Server 中读取参数
+func importCodec(name string, fns map[string]*ast.FuncDecl, values map[string][]ast.Expr, server *serverCall) *ImportedCodec {
+	codec := &ImportedCodec{Type: name}
+	cfg := Config{
+		Overwrite:  OverwriteMerge,
+		SoftDelete: SoftDeleteOff,
+		Tier:       TierRedis,
+		Encoding:   EncodingProtobuf,
+		Layout:     LayoutString,
+	}
+	issue := func(format string, args ...interface{}) {
+		codec.Issues = append(codec.Issues, fmt.Sprintf(format, args...))
+	}
+
+	// return &pb.EntityUserInfo{}
+	entity := ""
+	ast.Inspect(fns["Pt"].Body, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.CompositeLit); ok && entity == "" {
+			if sel, ok := lit.Type.(*ast.SelectorExpr); ok && isIdent(sel.X, "pb") {
+				entity = strings.TrimPrefix(sel.Sel.Name, "Entity")
+			}
+		}
+		return true
+	})
+
+	// return fmt.Sprintf(tableKeyUserInfo, key)
+	ast.Inspect(fns["Key"].Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if ok && codec.KeyFormat == "" && isSelector(call.Fun, "fmt", "Sprintf") && len(call.Args) > 0 {
+			codec.KeyFormat = literalValue(resolveValue(call.Args[0], values))
+		}
+		return true
+	})
+
+	// dao.UserInfo.Ctx(ctx).Where("uid = ?", key)
+	daoName := ""
+	for _, method := range []string{"One", "FindAll"} {
+		ast.Inspect(fns[method].Body, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.SelectorExpr:
+				if isIdent(node.X, "dao") && daoName == "" {
+					daoName = node.Sel.Name
+				}
+			case *ast.CallExpr:
+				if sel, ok := node.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Where" && len(node.Args) == 2 && cfg.UniqueKey == "" {
+					cfg.UniqueKey = whereColumn(literalValue(node.Args[0]))
+				}
+			}
+			return true
+		})
+	}
+	query := &CodecInfo{}
+	parseQuery(fns["FindAll"].Body, query)
+	cfg.Fields, cfg.Exclude, cfg.Where = query.Fields, query.Exclude, query.Where
+
+	if server != nil {
+		cfg.TableName = server.table
+		for _, arg := range server.call.Args {
+			switch {
+			case isSelectorPrefix(arg, "library", "Redis"):
+				cfg.RedisDb = toSnake(strings.TrimPrefix(arg.(*ast.SelectorExpr).Sel.Name, "Redis"))
+			case isCallOf(arg, "go2cache", "WithTtl"):
+				cfg.Seconds = evalSeconds(arg.(*ast.CallExpr).Args[0], server.scope)
+			}
+		}
+	}
+
+	if cfg.TableName == "" && daoName != "" {
+		cfg.TableName = toSnake(daoName)
+		issue("没有找到 TableCodecMap 的注册，表名按 dao.%s 推导为 %s", daoName, cfg.TableName)
+	}
+	if cfg.TableName == "" {
+		issue("没有找到表名，需要在声明文件中补充 table")
+	} else if entity != "" && entity != FirstUppers(cfg.TableName) {
+		issue("Pt 返回 pb.Entity%s，重新生成后使用 pb.Entity%s", entity, FirstUppers(cfg.TableName))
+	}
+	if cfg.UniqueKey == "" {
+		issue("One/FindAll 中没有找到唯一键的查询条件，需要在声明文件中补充 unique_key")
+	}
+	if server == nil {
+		issue("没有找到创建 %s 的 go2cache.NewOnlyRedisServer/NewServer，需要补充 redis_db 和 seconds", name)
+	} else {
+		if cfg.RedisDb == "" {
+			issue("创建 go2cache.Server 时没有使用 library.Redis*，需要在声明文件中补充 redis_db")
+		}
+		if cfg.Seconds == 0 {
+			issue("无法计算 go2cache.WithTtl 的过期时间，需要在声明文件中补充 seconds")
+		}
+	}
+
+	// 原来的key能按 [ns.]table.key.<table>.[v<N>.]<uq>.%d 解析时保留前缀和版本，否则重新生成后缓存key会变化
+	if cfg.TableName != "" && cfg.UniqueKey != "" {
+		if ns, version, err := parseKeyFormat(codec.KeyFormat, "table.key."+cfg.TableName+".", cfg.UniqueKey); err == nil {
+			cfg.Namespace, cfg.KeyVersion = ns, version
+		}
+		if format, err := KeyFormat(cfg); err == nil && format != codec.KeyFormat {
+			issue("重新生成后缓存key由 %q 变为 %q，上线后旧的缓存不再使用", codec.KeyFormat, format)
+		}
+	}
+	codec.Config = cfg
+	return codec
+}
+
+// findServers 找到所有创建 go2cache.Server 的调用，按传入的codec类型名返回
+func findServers(file *ast.File, values map[string][]ast.Expr) map[string]*serverCall {
+	// TableCodecMap["user_info"] = UserInfoRedisCodec
+	tables := make(map[string]string)
+	callTables := make(map[*ast.CallExpr]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			idx, ok := lhs.(*ast.IndexExpr)
+			if !ok || !isIdent(idx.X, "TableCodecMap") {
+				continue
+			}
+			switch rhs := assign.Rhs[i].(type) {
+			case *ast.Ident:
+				tables[rhs.Name] = literalValue(idx.Index)
+			case *ast.CallExpr:
+				// TableCodecMap["user_info"] = go2cache.NewOnlyRedisServer(...)
+				callTables[rhs] = literalValue(idx.Index)
+			}
+		}
+		return true
+	})
+
+	servers := make(map[string]*serverCall)
+	collect := func(node ast.Node, scope map[string][]ast.Expr) {
+		// 先记录赋值给了哪个变量
+		targets := make(map[*ast.CallExpr]string)
+		ast.Inspect(node, func(n ast.Node) bool {
+			switch s := n.(type) {
+			case *ast.AssignStmt:
+				for i := range s.Lhs {
+					if call, ok := rhsAt(s.Rhs, i).(*ast.CallExpr); ok {
+						if ident, ok := s.Lhs[i].(*ast.Ident); ok {
+							targets[call] = ident.Name
+						}
+					}
+				}
+			case *ast.ValueSpec:
+				for i, ident := range s.Names {
+					if call, ok := rhsAt(s.Values, i).(*ast.CallExpr); ok {
+						targets[call] = ident.Name
+					}
+				}
+			}
+			return true
+		})
+		ast.Inspect(node, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || !(isSelector(call.Fun, "go2cache", "NewOnlyRedisServer") || isSelector(call.Fun, "go2cache", "NewServer")) {
+				return true
+			}
+			typ := ""
+			for _, arg := range call.Args {
+				if typ = codecType(arg); typ != "" {
+					break
+				}
+			}
+			if typ == "" {
+				return true
+			}
+			table := callTables[call]
+			if name, ok := targets[call]; ok && table == "" {
+				table = tables[name]
+			}
+			servers[typ] = &serverCall{call: call, scope: scope, table: table}
+			return true
+		})
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			collect(d, values)
+		case *ast.FuncDecl:
+			if d.Body == nil {
+				continue
+			}
+			// 函数内的变量覆盖包级别的同名变量
+			scope := make(map[string][]ast.Expr)
+			for name, exprs := range values {
+				scope[name] = exprs
+			}
+			locals := make(map[string][]ast.Expr)
+			ast.Inspect(d.Body, func(n ast.Node) bool {
+				switch s := n.(type) {
+				case *ast.AssignStmt:
+					if s.Tok != token.ASSIGN && s.Tok != token.DEFINE {
+						return true
+					}
+					for i, lhs := range s.Lhs {
+						if ident, ok := lhs.(*ast.Ident); ok && rhsAt(s.Rhs, i) != nil {
+							locals[ident.Name] = append(locals[ident.Name], s.Rhs[i])
+						}
+					}
+				case *ast.GenDecl:
+					collectValues(s, locals)
+				}
+				return true
+			})
+			for name, exprs := range locals {
+				scope[name] = exprs
+			}
+			collect(d.Body, scope)
+		}
+	}
+	return servers
+}
+
+// collectValues 记录 const/var 声明的值，同名变量按出现顺序保存每次的值
+func collectValues(decl *ast.GenDecl, values map[string][]ast.Expr) {
+	if decl.Tok != token.CONST && decl.Tok != token.VAR {
+		return
+	}
+	for _, spec := range decl.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		for i, ident := range vs.Names {
+			if v := rhsAt(vs.Values, i); v != nil {
+				values[ident.Name] = append(values[ident.Name], v)
+			}
+		}
+	}
+}
+
+// evalSeconds 计算 go2cache.WithTtl 参数的秒数，变量被多次赋值时从最后一次往前找第一个能计算出正数的值
+func evalSeconds(expr ast.Expr, scope map[string][]ast.Expr) int64 {
+	ns, ok := evalInt(expr, scope, 0)
+	if !ok || ns < int64(time.Second) {
+		return 0
+	}
+	return ns / int64(time.Second)
+}
+
+// evalInt 计算由整数字面量、time 包的时长常量、类型转换和 + - * / 组成的表达式，时长以ns计
+func evalInt(expr ast.Expr, scope map[string][]ast.Expr, depth int) (int64, bool) {
+	if depth > 8 {
+		return 0, false
+	}
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.INT {
+			v, err := strconv.ParseInt(e.Value, 0, 64)
+			return v, err == nil
+		}
+	case *ast.ParenExpr:
+		return evalInt(e.X, scope, depth)
+	case *ast.Ident:
+		exprs := scope[e.Name]
+		for i := len(exprs) - 1; i >= 0; i-- {
+			if v, ok := evalInt(exprs[i], scope, depth+1); ok && v > 0 {
+				return v, true
+			}
+		}
+	case *ast.SelectorExpr:
+		if isIdent(e.X, "time") {
+			v, ok := timeUnits[e.Sel.Name]
+			return v, ok
+		}
+	case *ast.CallExpr:
+		// int64(10800)、time.Duration(expiredTtlUserInfoSeconds)
+		if len(e.Args) == 1 {
+			if _, ok := e.Fun.(*ast.Ident); ok || isSelector(e.Fun, "time", "Duration") {
+				return evalInt(e.Args[0], scope, depth)
+			}
+		}
+	case *ast.BinaryExpr:
+		x, ok := evalInt(e.X, scope, depth)
+		if !ok {
+			return 0, false
+		}
+		y, ok := evalInt(e.Y, scope, depth)
+		if !ok {
+			return 0, false
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, true
+		case token.SUB:
+			return x - y, true
+		case token.MUL:
+			return x * y, true
+		case token.QUO:
+			if y != 0 {
+				return x / y, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// resolveValue 参数是包级别的常量时返回常量的值
+func resolveValue(expr ast.Expr, values map[string][]ast.Expr) ast.Expr {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if exprs := values[ident.Name]; len(exprs) > 0 {
+			return exprs[len(exprs)-1]
+		}
+	}
+	return expr
+}
+
+// codecType &userInfoCodec{}、userInfoCodec{} 或 new(userInfoCodec) 中的类型名
+func codecType(expr ast.Expr) string {
+	if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.AND {
+		expr = unary.X
+	}
+	switch e := expr.(type) {
+	case *ast.CompositeLit:
+		if ident, ok := e.Type.(*ast.Ident); ok {
+			return ident.Name
+		}
+	case *ast.CallExpr:
+		if isIdent(e.Fun, "new") && len(e.Args) == 1 {
+			if ident, ok := e.Args[0].(*ast.Ident); ok {
+				return ident.Name
+			}
+		}
+	}
+	return ""
+}
+
+// recvType 方法接收者的类型名，(b userInfoCodec) 和 (b *userInfoCodec) 都返回 userInfoCodec
+func recvType(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func hasMethods(fns map[string]*ast.FuncDecl, names []string) bool {
+	for _, name := range names {
+		if _, ok := fns[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// rhsAt 多个变量赋值时的第 i 个值，a, b := f() 这种返回 nil
+func rhsAt(values []ast.Expr, i int) ast.Expr {
+	if len(values) == 0 || i >= len(values) {
+		return nil
+	}
+	return values[i]
+}
+
+func isSelector(expr ast.Expr, pkg, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+func isSelectorPrefix(expr ast.Expr, pkg, prefix string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && strings.HasPrefix(sel.Sel.Name, prefix)
+}
+
+func isCallOf(expr ast.Expr, pkg, name string) bool {
+	call, ok := expr.(*ast.CallExpr)
+	return ok && isSelector(call.Fun, pkg, name) && len(call.Args) == 1
+}
@@ -0,0 +1,173 @@
+package codecgen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// importSource 手写的 go2cache codec，占位符依次为 key格式、Where 条件和创建 Server 的代码
+const importSource = `package codec
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"slp/app/dao"
+	"slp/app/pb"
+	"slp/library"
+	"slp/library/go2cache"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const tableKeyUserInfo = %q
+
+type userInfoRedisCodec struct{}
+
+func (userInfoRedisCodec) Pt() proto.Message { return &pb.EntityUserInfo{} }
+
+func (userInfoRedisCodec) Key(key uint32) string { return fmt.Sprintf(tableKeyUserInfo, key) }
+
+func (userInfoRedisCodec) Pk(data proto.Message) uint32 { return data.(*pb.EntityUserInfo).Uid }
+
+func (userInfoRedisCodec) One(ctx context.Context, key uint32, data proto.Message) error {
+	return dao.UserInfo.Ctx(ctx).Where(%q, key).Struct(data)
+}
+
+func (userInfoRedisCodec) FindAll(ctx context.Context, keys []uint32, find go2cache.Find2Item) error {
+	_, err := dao.UserInfo.Ctx(ctx).Where("uid in (?)", keys).FindAll()
+	return err
+}
+
+%s
+`
+
+func TestImportCodecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyFormat string
+		where     string
+		server    string
+		want      func(t *testing.T, cfg Config)
+		issues    []string
+	}{
+		{
+			name:      "registered",
+			keyFormat: "table.key.user_info.uid.%d",
+			where:     "uid = ?",
+			server: `func init() {
+	TableCodecMap["user_info"] = go2cache.NewOnlyRedisServer(library.RedisUser, userInfoRedisCodec{}, go2cache.WithTtl(2*time.Minute))
+}`,
+			want: func(t *testing.T, cfg Config) {
+				if cfg.TableName != "user_info" || cfg.UniqueKey != "uid" || cfg.RedisDb != "user" || cfg.Seconds != 120 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name:      "ttl from local variable",
+			keyFormat: "table.key.user_info.uid.%d",
+			where:     "uid=?",
+			server: `var userInfoServer *go2cache.Server
+
+func init() {
+	ttl := 30
+	userInfoServer = go2cache.NewOnlyRedisServer(library.RedisUserInfo, &userInfoRedisCodec{}, go2cache.WithTtl(time.Duration(ttl)*time.Second))
+	TableCodecMap["user_info"] = userInfoServer
+}`,
+			want: func(t *testing.T, cfg Config) {
+				if cfg.RedisDb != "user_info" || cfg.Seconds != 30 || cfg.UniqueKey != "uid" {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name:      "namespace and version",
+			keyFormat: "room.table.key.user_info.v2.uid.%d",
+			where:     "uid = ?",
+			server: `func init() {
+	TableCodecMap["user_info"] = go2cache.NewOnlyRedisServer(library.RedisUser, userInfoRedisCodec{}, go2cache.WithTtl(time.Hour))
+}`,
+			want: func(t *testing.T, cfg Config) {
+				if cfg.Namespace != "room" || cfg.KeyVersion != 2 || cfg.Seconds != 3600 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name:      "custom key",
+			keyFormat: "user:%d",
+			where:     "uid = ?",
+			server: `func init() {
+	TableCodecMap["user_info"] = go2cache.NewOnlyRedisServer(library.RedisUser, userInfoRedisCodec{}, go2cache.WithTtl(time.Minute))
+}`,
+			want: func(t *testing.T, cfg Config) {
+				if cfg.Namespace != "" || cfg.KeyVersion != 0 || cfg.Seconds != 60 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+			issues: []string{`重新生成后缓存key由 "user:%d" 变为 "table.key.user_info.uid.%d"`},
+		},
+		{
+			name:      "without server",
+			keyFormat: "table.key.user_info.uid.%d",
+			where:     "uid = ?",
+			want: func(t *testing.T, cfg Config) {
+				if cfg.TableName != "user_info" || cfg.RedisDb != "" || cfg.Seconds != 0 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+			issues: []string{
+				"没有找到 TableCodecMap 的注册，表名按 dao.UserInfo 推导为 user_info",
+				"没有找到创建 userInfoRedisCodec 的 go2cache.NewOnlyRedisServer/NewServer",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "user_info.go")
+			src := fmt.Sprintf(importSource, tt.keyFormat, tt.where, tt.server)
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			codecs, err := ImportCodecs(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(codecs) != 1 || codecs[0].Type != "userInfoRedisCodec" {
+				t.Fatalf("codecs = %+v", codecs)
+			}
+			codec := codecs[0]
+			if codec.KeyFormat != tt.keyFormat {
+				t.Errorf("KeyFormat = %q, want %q", codec.KeyFormat, tt.keyFormat)
+			}
+			if codec.Config.Module != "slp" || codec.Config.Package != "codec" {
+				t.Errorf("config = %+v", codec.Config)
+			}
+			tt.want(t, codec.Config)
+			issues := strings.Join(codec.Issues, "\n")
+			if len(tt.issues) == 0 && issues != "" {
+				t.Errorf("issues = %s", issues)
+			}
+			for _, want := range tt.issues {
+				if !strings.Contains(issues, want) {
+					t.Errorf("issues = %s, want %q", issues, want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportCodecsWithoutCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gift.go")
+	if err := os.WriteFile(path, []byte(handWrittenCodec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportCodecs(path); err == nil || !strings.Contains(err.Error(), "没有实现 Pt/Key/Pk/One/FindAll 的类型") {
+		t.Errorf("ImportCodecs() error = %v", err)
+	}
+}
@@ -0,0 +1,415 @@
+package codecgen
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// CodecInfo 从已生成的codec文件中读取的缓存参数
+type CodecInfo struct {
+	File         string   `json:"file"`
+	Package      string   `json:"package"`
+	Table        string   `json:"table"`
+	Entity       string   `json:"entity"`
+	KeyColumn    string   `json:"key_column"`
+	TtlSeconds   int64    `json:"ttl_seconds"`
+	RedisDb      string   `json:"redis_db"`
+	KeyFormat    string   `json:"key_format"`
+	Module       string   `json:"module"`
+	Fields       []string `json:"fields,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	Where        []string `json:"where,omitempty"`
+	NotFoundTtl  int64    `json:"not_found_ttl,omitempty"` // 大于0表示缓存了不存在的记录
+	Jitter       int      `json:"jitter,omitempty"`
+	TtlHook      bool     `json:"ttl_hook,omitempty"`
+	LocalSize    int      `json:"local_size,omitempty"` // 大于0表示 -tier local
+	LocalTtl     int64    `json:"local_ttl,omitempty"`
+	List         string   `json:"list,omitempty"`     // 列表codec缓存的非唯一列，KeyColumn 是列表中的唯一键
+	Encoding     string   `json:"encoding,omitempty"` // json 或 msgpack，为空表示 protobuf
+	Layout       string   `json:"layout,omitempty"`   // hash，为空表示 string
+	Metrics      bool     `json:"metrics,omitempty"`
+	ShardCount   int      `json:"shard_count,omitempty"`
+	ShardFunc    string   `json:"shard_func,omitempty"`
+	ShardWidth   int      `json:"shard_width,omitempty"`
+	Chunk        int      `json:"chunk,omitempty"`
+	LoadTimeout  int64    `json:"load_timeout,omitempty"` // 单位ms
+	SingleFlight bool     `json:"single_flight,omitempty"`
+	Issues       []string `json:"issues,omitempty"`
+
+	order   string // 查询的排序列
+	daoName string // 第一个使用的 dao 变量
+	ttlVar  string // expiredTtl<PbName>Seconds 的变量名
+	keyVar  string // tableKey<PbName> 的常量名
+	legacy  bool   // 按变量名判断是之前版本的模板生成的
+}
+
+// SkippedFile 目录中匹配 *_codec.go 但不是 slpctl 生成的codec文件，list 和 upgrade 跳过
+type SkippedFile struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// ListCodecs 解析目录下所有 slpctl 生成的 *_codec.go，手写的codec等无法识别的文件放在 skipped 中返回。
+// 有 generatedHeader 的文件是当前版本生成的；没有的按之前版本的模板识别，需要有 tableKey<PbName> 和 expiredTtl<PbName>Seconds
+func ListCodecs(dir string) ([]*CodecInfo, []SkippedFile, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_codec.go"))
+	if err != nil {
+		return nil, nil, err
+	}
+	infos := []*CodecInfo{}
+	var skipped []SkippedFile
+	for _, path := range files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		generated := strings.HasPrefix(string(content), generatedHeader)
+		info, err := ParseCodecFile(path)
+		switch {
+		case !generated && (err != nil || !info.legacy):
+			skipped = append(skipped, SkippedFile{File: path, Reason: "没有 slpctl 的生成标记，也不是之前版本生成的codec"})
+			continue
+		case err != nil:
+			skipped = append(skipped, SkippedFile{File: path, Reason: strings.TrimPrefix(err.Error(), path+" 中")})
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Table != infos[j].Table {
+			return infos[i].Table < infos[j].Table
+		}
+		return infos[i].List < infos[j].List
+	})
+	return infos, skipped, nil
+}
+
+// WriteSkipped 输出 list 和 upgrade 跳过的文件
+func WriteSkipped(w io.Writer, skipped []SkippedFile) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n跳过 %d 个无法识别的文件，手写的codec可以用 slpctl codec import 导入声明文件后重新生成:\n", len(skipped))
+	for _, f := range skipped {
+		fmt.Fprintf(w, "  %s: %s\n", f.File, f.Reason)
+	}
+}
+
+// ParseCodecFile 从codec文件的语法树中读取表名、entity、唯一键、过期时间、redis db、key 格式和生成时的选项
+func ParseCodecFile(path string) (*CodecInfo, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	info := &CodecInfo{File: path, Package: file.Name.Name}
+
+	for _, imp := range file.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		switch {
+		case err != nil:
+		case strings.HasSuffix(p, "/app/dao"):
+			info.Module = strings.TrimSuffix(p, "/app/dao")
+		case p == "encoding/json":
+			info.Encoding = EncodingJSON
+		case strings.HasPrefix(p, "github.com/vmihailenco/msgpack"):
+			info.Encoding = EncodingMsgpack
+		case p == "golang.org/x/sync/singleflight":
+			// 之前版本的 -singleflight 使用 x/sync
+			info.SingleFlight = true
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.ValueSpec:
+			if isIdent(node.Type, "KeyFlight") {
+				info.SingleFlight = true
+			}
+			for i, name := range node.Names {
+				if i >= len(node.Values) {
+					continue
+				}
+				switch {
+				case strings.HasPrefix(name.Name, "expiredTtl") && strings.HasSuffix(name.Name, "Seconds"):
+					info.TtlSeconds, _ = strconv.ParseInt(literalValue(node.Values[i]), 10, 64)
+					info.ttlVar = name.Name
+				case strings.HasPrefix(name.Name, "tableKey"):
+					info.KeyFormat = literalValue(node.Values[i])
+					info.keyVar = name.Name
+				case strings.HasPrefix(name.Name, "notFoundTtl") && strings.HasSuffix(name.Name, "Seconds"):
+					info.NotFoundTtl, _ = strconv.ParseInt(literalValue(node.Values[i]), 10, 64)
+				case strings.HasPrefix(name.Name, "localTtl") && strings.HasSuffix(name.Name, "Seconds"):
+					info.LocalTtl, _ = strconv.ParseInt(literalValue(node.Values[i]), 10, 64)
+				case strings.HasPrefix(name.Name, "loadTimeout") && strings.HasSuffix(name.Name, "Millis"):
+					info.LoadTimeout, _ = strconv.ParseInt(literalValue(node.Values[i]), 10, 64)
+				case strings.HasPrefix(name.Name, "expiredJitter") && strings.HasSuffix(name.Name, "Percent"):
+					info.Jitter, _ = strconv.Atoi(literalValue(node.Values[i]))
+				}
+			}
+		case *ast.FuncDecl:
+			if strings.HasSuffix(node.Name.Name, "Shard") && node.Recv == nil && node.Body != nil {
+				// return int(key % 100)
+				ast.Inspect(node.Body, func(n ast.Node) bool {
+					if expr, ok := n.(*ast.BinaryExpr); ok && expr.Op == token.REM {
+						info.ShardCount, _ = strconv.Atoi(literalValue(expr.Y))
+					}
+					return true
+				})
+			}
+			switch node.Name.Name {
+			case "TtlOf":
+				info.TtlHook = node.Recv != nil
+			case "FindAll", "findAll", "loadFindAll":
+				if node.Recv != nil && node.Body != nil {
+					parseQuery(node.Body, info)
+				}
+			}
+		case *ast.AssignStmt:
+			// TableCodecMap["user_info"] = UserInfoRedisCodec
+			if idx, ok := node.Lhs[0].(*ast.IndexExpr); ok && isIdent(idx.X, "TableCodecMap") && info.Table == "" {
+				info.Table = literalValue(idx.Index)
+			}
+			// EntityCodecMap["user_info"] = UserInfoEntityCodec
+			if idx, ok := node.Lhs[0].(*ast.IndexExpr); ok && isIdent(idx.X, "EntityCodecMap") && info.Table == "" {
+				info.Table = literalValue(idx.Index)
+			}
+			// ListCodecMap["user_info.room_id"] = UserInfoByRoomIdListCodec
+			if idx, ok := node.Lhs[0].(*ast.IndexExpr); ok && isIdent(idx.X, "ListCodecMap") && info.Table == "" {
+				info.Table, info.List, _ = strings.Cut(literalValue(idx.Index), ".")
+			}
+		case *ast.SelectorExpr:
+			if isIdent(node.X, "crc32") {
+				info.ShardFunc = ShardCrc32
+			}
+			if isIdent(node.X, "dao") && info.daoName == "" {
+				info.daoName = node.Sel.Name
+			}
+			if isIdent(node.X, "library") && strings.HasPrefix(node.Sel.Name, "Redis") && info.RedisDb == "" {
+				info.RedisDb = toSnake(strings.TrimPrefix(node.Sel.Name, "Redis"))
+			}
+		case *ast.CompositeLit:
+			// return &pb.EntityUserInfo{} 或 data := &model.UserInfo{}
+			if sel, ok := node.Type.(*ast.SelectorExpr); ok && (isIdent(sel.X, "pb") || isIdent(sel.X, "model")) && info.Entity == "" {
+				info.Entity = exprString(sel)
+			}
+		case *ast.CallExpr:
+			// .Where("uid = ?", key)
+			if sel, ok := node.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Where" && len(node.Args) == 2 && info.KeyColumn == "" {
+				info.KeyColumn = whereColumn(literalValue(node.Args[0]))
+			}
+			// library.RedisUser.HMGet(ctx, key, fields...)
+			if sel, ok := node.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "HMGet" {
+				info.Layout = LayoutHash
+			}
+			// Chunks(keys, 200)
+			if isIdent(node.Fun, "Chunks") && len(node.Args) == 2 {
+				info.Chunk, _ = strconv.Atoi(literalValue(node.Args[1]))
+			}
+			if isIdent(node.Fun, "ObserveLoad") {
+				info.Metrics = true
+			}
+			// NewLocalCache(1000, ...)
+			if isIdent(node.Fun, "NewLocalCache") && len(node.Args) == 2 {
+				info.LocalSize, _ = strconv.Atoi(literalValue(node.Args[0]))
+			}
+		}
+		return true
+	})
+
+	if info.Table == "" {
+		return nil, fmt.Errorf("%s 中没有找到 TableCodecMap 的注册，不是 slpctl 生成的codec文件", path)
+	}
+	pbName := FirstUppers(info.Table)
+	info.legacy = info.keyVar == "tableKey"+pbName && info.ttlVar == "expiredTtl"+pbName+"Seconds"
+	if info.ShardCount == 0 {
+		info.ShardFunc = ""
+	} else {
+		// dao.UserLog00 的后缀位数
+		info.ShardWidth = len(info.daoName) - len(FirstUppers(info.Table))
+		if info.ShardFunc == "" {
+			info.ShardFunc = ShardMod
+		}
+	}
+	if info.List != "" {
+		// 列表codec按 Order 中的唯一键排序，Fields 固定为 <list>,<uq>
+		info.KeyColumn, info.Fields = info.order, nil
+	}
+	return info, nil
+}
+
+// parseQuery 从 FindAll 中 dao.X.Ctx(ctx).Where("uid in (?)", keys).Where("deleted = 0").FieldsEx("description").FindAll()
+// 这样的调用链读取唯一键之后的查询条件、列裁剪和排序，-encoding json/msgpack 的codec以 Structs 结尾
+func parseQuery(body *ast.BlockStmt, info *CodecInfo) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "FindAll" && sel.Sel.Name != "Structs") {
+			return true
+		}
+		// 调用链从外向内是倒序的
+		var chain []*ast.CallExpr
+		for expr := sel.X; ; {
+			c, ok := expr.(*ast.CallExpr)
+			if !ok {
+				break
+			}
+			s, ok := c.Fun.(*ast.SelectorExpr)
+			if !ok {
+				break
+			}
+			chain = append([]*ast.CallExpr{c}, chain...)
+			expr = s.X
+		}
+		for _, c := range chain {
+			if len(c.Args) != 1 {
+				continue
+			}
+			switch c.Fun.(*ast.SelectorExpr).Sel.Name {
+			case "Where":
+				info.Where = append(info.Where, literalValue(c.Args[0]))
+			case "Fields":
+				info.Fields = SplitList(literalValue(c.Args[0]))
+			case "FieldsEx":
+				info.Exclude = SplitList(literalValue(c.Args[0]))
+			case "Order":
+				info.order = literalValue(c.Args[0])
+			}
+		}
+		return false
+	})
+}
+
+// literalValue 读取字符串或整数字面量，int64(10800) 这种转换也能读取
+func literalValue(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, _ := strconv.Unquote(e.Value)
+			return s
+		}
+		return e.Value
+	case *ast.CallExpr:
+		if len(e.Args) == 1 {
+			return literalValue(e.Args[0])
+		}
+	case *ast.ParenExpr:
+		return literalValue(e.X)
+	}
+	return ""
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// whereColumn "uid = ?" -> uid
+func whereColumn(where string) string {
+	fields := strings.FieldsFunc(where, func(r rune) bool {
+		return r == ' ' || r == '=' || r == '?' || r == '(' || r == ')'
+	})
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], "`")
+}
+
+// Policy 项目对缓存的约定，声明文件中的约定会在生成前检查，codec list 读取声明文件并合并命令行参数后标记不符合的codec
+type Policy struct {
+	MinTtl int64    `json:"min_ttl,omitempty"` // 最短过期时间，单位s，0 表示不限制
+	MaxTtl int64    `json:"max_ttl,omitempty"` // 最长过期时间，单位s，0 表示不限制
+	Dbs    []string `json:"dbs,omitempty"`     // 允许使用的redis db，为空表示不限制
+}
+
+// Check 返回不符合约定的地方
+func (p Policy) Check(info *CodecInfo) []string {
+	return p.check(info.TtlSeconds, info.RedisDb)
+}
+
+// Override 用 o 中设置了的约定覆盖 p，codec list 的命令行参数覆盖声明文件中的 policy
+func (p Policy) Override(o Policy) Policy {
+	if o.MinTtl > 0 {
+		p.MinTtl = o.MinTtl
+	}
+	if o.MaxTtl > 0 {
+		p.MaxTtl = o.MaxTtl
+	}
+	if len(o.Dbs) > 0 {
+		p.Dbs = o.Dbs
+	}
+	return p
+}
+
+// CheckConfig 生成前检查codec参数，返回不符合约定的地方
+func (p Policy) CheckConfig(cfg Config) []string {
+	return p.check(cfg.ttlSeconds(), cfg.RedisDb)
+}
+
+func (p Policy) check(ttl int64, db string) []string {
+	var issues []string
+	if p.MinTtl > 0 && ttl < p.MinTtl {
+		issues = append(issues, fmt.Sprintf("过期时间 %ds 小于 %ds", ttl, p.MinTtl))
+	}
+	if p.MaxTtl > 0 && ttl > p.MaxTtl {
+		issues = append(issues, fmt.Sprintf("过期时间 %ds 大于 %ds", ttl, p.MaxTtl))
+	}
+	if len(p.Dbs) > 0 && !containsString(p.Dbs, db) {
+		issues = append(issues, fmt.Sprintf("redis db %s 不在 %s 中", db, strings.Join(p.Dbs, ",")))
+	}
+	return issues
+}
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// WriteCodecList 按 table、json 或 csv 输出codec列表
+func WriteCodecList(w io.Writer, infos []*CodecInfo, format string) error {
+	header := []string{"TABLE", "ENTITY", "KEY", "TTL(s)", "REDIS", "KEY FORMAT", "ISSUES"}
+	row := func(info *CodecInfo) []string {
+		table := info.Table
+		if info.List != "" {
+			table += "." + info.List
+		}
+		return []string{table, info.Entity, info.KeyColumn, strconv.FormatInt(info.TtlSeconds, 10),
+			info.RedisDb, info.KeyFormat, strings.Join(info.Issues, "; ")}
+	}
+	switch format {
+	case "", FormatTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, info := range infos {
+			fmt.Fprintln(tw, strings.Join(row(info), "\t"))
+		}
+		return tw.Flush()
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, info := range infos {
+			cw.Write(row(info))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("未知的输出格式 %q，可选 table,json,csv", format)
+}
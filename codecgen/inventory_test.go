@@ -0,0 +1,166 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handWrittenCodec 项目中手写的codec，注册方式和 slpctl 生成的不同
+const handWrittenCodec = `package codec
+
+import "fmt"
+
+type giftCodec struct{}
+
+func (giftCodec) Key(id uint32) string { return fmt.Sprintf("gift:%d", id) }
+`
+
+// testCodecConfig 不依赖项目目录的生成参数，输出到 dir
+func testCodecConfig(dir string) Config {
+	return Config{
+		TableName: "user_info",
+		Seconds:   60,
+		RedisDb:   "user",
+		UniqueKey: "uid",
+		Module:    "slp",
+		OutputDir: dir,
+		Package:   "codec",
+		Overwrite: OverwriteForce,
+		SkipCheck: true,
+	}
+}
+
+func TestParseCodecFileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(cfg *Config)
+		file string
+		want func(t *testing.T, info *CodecInfo)
+	}{
+		{
+			name: "protobuf",
+			edit: func(cfg *Config) { cfg.NotFound, cfg.Jitter, cfg.Metrics = true, 10, true },
+			file: "user_info_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.NotFoundTtl != DefaultNotFoundTtl || info.Jitter != 10 || !info.Metrics || info.Entity != "pb.EntityUserInfo" {
+					t.Errorf("info = %+v", info)
+				}
+			},
+		},
+		{
+			name: "json",
+			edit: func(cfg *Config) { cfg.Encoding = EncodingJSON },
+			file: "user_info_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.Encoding != EncodingJSON || info.Entity != "model.UserInfo" {
+					t.Errorf("info = %+v", info)
+				}
+			},
+		},
+		{
+			name: "hash",
+			edit: func(cfg *Config) { cfg.Layout = LayoutHash },
+			file: "user_info_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.Layout != LayoutHash {
+					t.Errorf("Layout = %q, want hash", info.Layout)
+				}
+			},
+		},
+		{
+			name: "shards",
+			edit: func(cfg *Config) { cfg.ShardCount, cfg.ShardFunc = 16, ShardCrc32 },
+			file: "user_info_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.ShardCount != 16 || info.ShardFunc != ShardCrc32 || info.ShardWidth != 2 {
+					t.Errorf("shards = %d %s %d", info.ShardCount, info.ShardFunc, info.ShardWidth)
+				}
+			},
+		},
+		{
+			name: "local tier",
+			edit: func(cfg *Config) { cfg.Tier, cfg.LocalSize, cfg.LocalTtl = TierLocal, 500, 30 },
+			file: "user_info_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.LocalSize != 500 || info.LocalTtl != 30 {
+					t.Errorf("local = %d %d", info.LocalSize, info.LocalTtl)
+				}
+			},
+		},
+		{
+			name: "list",
+			edit: func(cfg *Config) { cfg.UniqueKey, cfg.List = "id", "room_id" },
+			file: "user_info_room_id_list_codec.go",
+			want: func(t *testing.T, info *CodecInfo) {
+				if info.List != "room_id" || info.KeyColumn != "id" {
+					t.Errorf("list = %q, key = %q", info.List, info.KeyColumn)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := testCodecConfig(dir)
+			tt.edit(&cfg)
+			if err := CodecExec(cfg); err != nil {
+				t.Fatal(err)
+			}
+			info, err := ParseCodecFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, _ := KeyFormat(cfg)
+			if info.Table != "user_info" || info.TtlSeconds != 60 || info.RedisDb != "user" || info.KeyFormat != format || info.Module != "slp" {
+				t.Errorf("ParseCodecFile() = %+v", info)
+			}
+			tt.want(t, info)
+
+			// 还原的参数重新生成，结果不变
+			restored, err := info.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.Encoding != cfg.Encoding || restored.Layout != cfg.Layout || restored.ShardCount != cfg.ShardCount || restored.List != cfg.List {
+				t.Errorf("Config() = %+v, want %+v", restored, cfg)
+			}
+		})
+	}
+}
+
+func TestListCodecsSkipsHandWritten(t *testing.T) {
+	dir := t.TempDir()
+	if err := CodecExec(testCodecConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gift_codec.go"), []byte(handWrittenCodec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 之前版本生成的文件没有 generatedHeader，按变量名识别
+	content, err := os.ReadFile(filepath.Join(dir, "user_info_codec.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := strings.ReplaceAll(strings.TrimPrefix(string(content), generatedHeader), "UserInfo", "Room")
+	legacy = strings.ReplaceAll(legacy, "user_info", "room")
+	if err = os.WriteFile(filepath.Join(dir, "room_codec.go"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, skipped, err := ListCodecs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for _, info := range infos {
+		tables = append(tables, info.Table)
+	}
+	if got := strings.Join(tables, ","); got != "room,user_info" {
+		t.Errorf("ListCodecs() tables = %s, want room,user_info", got)
+	}
+	if len(skipped) != 1 || filepath.Base(skipped[0].File) != "gift_codec.go" {
+		t.Errorf("ListCodecs() skipped = %+v, want gift_codec.go", skipped)
+	}
+}
@@ -0,0 +1,205 @@
+package codecgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// LibraryDir 项目中定义 redis 连接的 library 包目录
+const LibraryDir = "library"
+
+// RedisDbs 解析 library 包中定义的 Redis* 变量，返回 -d 参数可用的名字，如 RedisRoomGame 对应 room_game。
+// 目录不存在时返回 nil
+func RedisDbs(dir string) ([]string, error) {
+	b, err := PathExists(dir)
+	if err != nil || !b {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					if name := strings.TrimPrefix(ident.Name, "Redis"); name != ident.Name && name != "" && unicode.IsUpper(rune(name[0])) {
+						names = append(names, toSnake(name))
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkRedisDb 校验 -d 参数在 library 包中有对应的 Redis* 变量，读取不到 library 包时只给出提示
+func checkRedisDb(db string) error {
+	dbs, err := RedisDbs(LibraryDir)
+	if err != nil {
+		return err
+	}
+	if len(dbs) == 0 {
+		fmt.Printf("警告: 没有在 %s 中找到 Redis* 变量，跳过 -d 参数校验\n", LibraryDir)
+		return nil
+	}
+	want := FirstUppers(db)
+	for _, name := range dbs {
+		if FirstUppers(name) == want {
+			return nil
+		}
+	}
+	msg := fmt.Sprintf("library 包中没有定义 Redis%s，-d 可选: %s", want, strings.Join(dbs, ","))
+	if similar := suggest(db, dbs); len(similar) > 0 {
+		msg = fmt.Sprintf("%s；你是不是想用: %s", msg, strings.Join(similar, ","))
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// testRedis 测试时把 library 中的 redis 连接替换成 miniredis 的方式，只支持 go-redis 的 *redis.Client
+type testRedis struct {
+	Import string   // go-redis 的import路径
+	Vars   []string // 类型为 *redis.Client 的 Redis* 变量
+}
+
+// goRedisClients 解析 library 包中类型为 go-redis *redis.Client 的 Redis* 变量，没有时返回 nil
+func goRedisClients(dir string) (*testRedis, error) {
+	b, err := PathExists(dir)
+	if err != nil || !b {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var redis *testRedis
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+		}
+		imports := make(map[string]string)
+		for _, imp := range file.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			if !strings.HasPrefix(p, "github.com/go-redis/redis") && !strings.HasPrefix(p, "github.com/redis/go-redis") {
+				continue
+			}
+			name := "redis"
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			imports[name] = p
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, ident := range vs.Names {
+					if !strings.HasPrefix(ident.Name, "Redis") {
+						continue
+					}
+					// var RedisUser *redis.Client 或 RedisUser = redis.NewClient(...)
+					var pkg string
+					if star, ok := vs.Type.(*ast.StarExpr); ok {
+						if sel, ok := star.X.(*ast.SelectorExpr); ok && sel.Sel.Name == "Client" {
+							pkg = exprString(sel.X)
+						}
+					} else if vs.Type == nil && i < len(vs.Values) {
+						if call, ok := vs.Values[i].(*ast.CallExpr); ok {
+							if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "NewClient" {
+								pkg = exprString(sel.X)
+							}
+						}
+					}
+					if p, ok := imports[pkg]; ok {
+						if redis == nil {
+							redis = &testRedis{Import: p}
+						}
+						if redis.Import == p {
+							redis.Vars = append(redis.Vars, ident.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	if redis != nil {
+		sort.Strings(redis.Vars)
+	}
+	return redis, nil
+}
+
+// suggest 返回和输入相近的候选项
+func suggest(input string, candidates []string) []string {
+	input = strings.ToLower(input)
+	var similar []string
+	for _, c := range candidates {
+		if strings.Contains(c, input) || strings.Contains(input, c) || levenshtein(input, c) <= 2 {
+			similar = append(similar, c)
+		}
+	}
+	return similar
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// toSnake RoomGame -> room_game
+func toSnake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
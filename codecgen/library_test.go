@@ -0,0 +1,75 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const librarySrc = `package library
+
+import "github.com/go-redis/redis/v8"
+
+var (
+	RedisUser     *redis.Client
+	RedisRoomGame = redis.NewClient(&redis.Options{})
+	Redis         *redis.Client
+	Redisx        *redis.Client
+	redisLocal    *redis.Client
+)
+`
+
+// useProject 在临时目录中写入项目文件并切换过去，生成前的校验按相对路径读取项目
+func useProject(t *testing.T, files map[string]string) {
+	t.Helper()
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(root) })
+}
+
+func TestCheckRedisDb(t *testing.T) {
+	useProject(t, map[string]string{"library/redis.go": librarySrc})
+	dbs, err := RedisDbs(LibraryDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"room_game", "user"}; !reflect.DeepEqual(dbs, want) {
+		t.Errorf("RedisDbs() = %v, want %v", dbs, want)
+	}
+
+	tests := []struct {
+		db      string
+		wantErr string // 为空表示校验通过
+	}{
+		{"user", ""},
+		{"room_game", ""},
+		{"roomGame", ""},
+		{"usr", "library 包中没有定义 RedisUsr，-d 可选: room_game,user；你是不是想用: user"},
+		{"passive", "library 包中没有定义 RedisPassive，-d 可选: room_game,user"},
+	}
+	for _, tt := range tests {
+		err := checkRedisDb(tt.db)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("checkRedisDb(%q) = %v, want nil", tt.db, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("checkRedisDb(%q) = %v, want %q", tt.db, err, tt.wantErr)
+		}
+	}
+}
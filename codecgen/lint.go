@@ -0,0 +1,341 @@
+package codecgen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeySpace 一个codec使用的缓存key
+type KeySpace struct {
+	Source  string   // 声明文件或codec文件的路径
+	Dir     string   // codec 所在目录
+	Name    string   // 表名，列表codec为 <table>.<list>
+	RedisDb string   // 为空表示没有识别出来
+	Formats []string // 缓存key的格式，-notfound 时包含 .nil 结尾的不存在记录的标记
+}
+
+// KeyConflict 两个codec的缓存key冲突
+type KeyConflict struct {
+	A, B    *KeySpace
+	Example string // 两个codec都会使用的key，为空表示只是前缀重叠
+	Warning bool   // 只是前缀重叠、redis db 不同或同一张表在不同目录的codec，不阻止生成
+}
+
+func (c KeyConflict) String() string {
+	a := fmt.Sprintf("%s(%s)", c.A.Name, c.A.Source)
+	b := fmt.Sprintf("%s(%s)", c.B.Name, c.B.Source)
+	switch {
+	case c.Example == "":
+		pa, pb := keyPrefix(c.A.Formats[0]), keyPrefix(c.B.Formats[0])
+		if len(pa) < len(pb) {
+			a, b, pa, pb = b, a, pb, pa
+		}
+		return fmt.Sprintf("%s 的缓存key前缀 %q 以 %s 的前缀 %q 开头，按前缀扫描或删除时会互相影响", a, pa, b, pb)
+	case c.A.Name == c.B.Name:
+		return fmt.Sprintf("表 %s 在 %s 和 %s 中都有codec，共用缓存key %s 时缓存的数据格式需要一致", c.A.Name, c.A.Source, c.B.Source, c.Example)
+	case c.A.RedisDb != c.B.RedisDb:
+		return fmt.Sprintf("%s 和 %s 的缓存key冲突，如 %s，redis db 分别为 %s 和 %s，指向同一个实例时会互相覆盖",
+			a, b, c.Example, c.A.RedisDb, c.B.RedisDb)
+	}
+	return fmt.Sprintf("%s 和 %s 的缓存key冲突，如 %s", a, b, c.Example)
+}
+
+// ConfigKeySpace 按生成参数计算codec的缓存key
+func ConfigKeySpace(source string, cfg Config) (*KeySpace, error) {
+	format, err := KeyFormat(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", codecName(cfg.TableName, cfg.List), err)
+	}
+	dir := cfg.OutputDir
+	if dir == "" {
+		dir = DefaultOutputDir
+	}
+	space := &KeySpace{
+		Source:  source,
+		Dir:     filepath.Clean(dir),
+		Name:    codecName(cfg.TableName, cfg.List),
+		RedisDb: cfg.RedisDb,
+		Formats: []string{format},
+	}
+	if cfg.NotFound && cfg.List == "" {
+		space.Formats = append(space.Formats, format+notFoundSuffix)
+	}
+	return space, nil
+}
+
+// SpecKeySpaces 声明文件中所有codec的缓存key
+func SpecKeySpaces(path string, spec *Spec) ([]*KeySpace, error) {
+	configs, err := spec.Configs(nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var spaces []*KeySpace
+	for _, cfg := range configs {
+		space, err := ConfigKeySpace(path, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		spaces = append(spaces, space)
+	}
+	return spaces, nil
+}
+
+// DirKeySpaces 目录下已有codec的缓存key，slpctl 生成的文件按 codec list 的方式读取，
+// 其他文件按 codec import 的方式识别手写的codec，都识别不了的文件跳过。目录不存在时返回 nil
+func DirKeySpaces(dir string) ([]*KeySpace, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var spaces []*KeySpace
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(content), generatedHeader) {
+			// codec_support.go、预热文件等没有注册codec，解析失败时跳过
+			info, err := ParseCodecFile(path)
+			if err != nil || info.KeyFormat == "" {
+				continue
+			}
+			space := &KeySpace{
+				Source:  path,
+				Dir:     filepath.Clean(dir),
+				Name:    codecName(info.Table, info.List),
+				RedisDb: info.RedisDb,
+				Formats: []string{info.KeyFormat},
+			}
+			if info.NotFoundTtl > 0 {
+				space.Formats = append(space.Formats, info.KeyFormat+notFoundSuffix)
+			}
+			spaces = append(spaces, space)
+			continue
+		}
+		codecs, err := ImportCodecs(path)
+		if err != nil {
+			continue
+		}
+		for _, codec := range codecs {
+			if codec.KeyFormat == "" {
+				continue
+			}
+			spaces = append(spaces, &KeySpace{
+				Source:  path,
+				Dir:     filepath.Clean(dir),
+				Name:    codec.Config.TableName,
+				RedisDb: codec.Config.RedisDb,
+				Formats: []string{codec.KeyFormat},
+			})
+		}
+	}
+	return spaces, nil
+}
+
+// LintConfigs 检查将要生成的codec之间，以及和输出目录中已有codec的缓存key冲突
+func LintConfigs(source string, configs []Config) ([]KeyConflict, error) {
+	var spaces []*KeySpace
+	dirs := make(map[string]bool)
+	for _, cfg := range configs {
+		space, err := ConfigKeySpace(source, cfg)
+		if err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, space)
+		dirs[space.Dir] = true
+	}
+	for dir := range dirs {
+		existing, err := DirKeySpaces(dir)
+		if err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, existing...)
+	}
+	return LintKeySpaces(spaces), nil
+}
+
+// LintKeySpaces 两两比较codec的缓存key，返回可能生成相同key或前缀重叠的codec。
+// 同一目录中同名的codec是声明文件和按它生成的文件，不做比较
+func LintKeySpaces(spaces []*KeySpace) []KeyConflict {
+	var conflicts []KeyConflict
+	for i, a := range spaces {
+		for _, b := range spaces[i+1:] {
+			if a.Name == b.Name && a.Dir == b.Dir {
+				continue
+			}
+			if conflict, ok := compareKeySpaces(a, b); ok {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return !conflicts[i].Warning && conflicts[j].Warning
+	})
+	return conflicts
+}
+
+// HasKeyErrors 是否有需要阻止生成的冲突
+func HasKeyErrors(conflicts []KeyConflict) bool {
+	for _, c := range conflicts {
+		if !c.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func compareKeySpaces(a, b *KeySpace) (KeyConflict, bool) {
+	conflict := KeyConflict{A: a, B: b}
+	for _, fa := range a.Formats {
+		for _, fb := range b.Formats {
+			if example, ok := intersectKeys(parseKeyTokens(fa), parseKeyTokens(fb)); ok {
+				conflict.Example = example
+				conflict.Warning = a.Name == b.Name || (a.RedisDb != "" && b.RedisDb != "" && a.RedisDb != b.RedisDb)
+				return conflict, true
+			}
+		}
+	}
+	// 没有相同的key，但一个codec的key前缀以另一个的前缀开头
+	pa, pb := keyPrefix(a.Formats[0]), keyPrefix(b.Formats[0])
+	if pa != "" && pb != "" && (strings.HasPrefix(pa, pb) || strings.HasPrefix(pb, pa)) {
+		conflict.Warning = true
+		return conflict, true
+	}
+	return conflict, false
+}
+
+// keyPrefix 格式中第一个占位符之前的部分
+func keyPrefix(format string) string {
+	if idx := strings.Index(format, "%"); idx >= 0 {
+		return format[:idx]
+	}
+	return format
+}
+
+// keyToken 缓存key格式中的一个字符或一类字符，star 表示可以重复0次或多次
+type keyToken struct {
+	c     byte // 0 表示一类字符
+	digit bool // c 为 0 时，true 只匹配数字，false 匹配任意字符
+	star  bool
+}
+
+func (t keyToken) match(c byte) bool {
+	switch {
+	case t.c != 0:
+		return t.c == c
+	case t.digit:
+		return c >= '0' && c <= '9'
+	}
+	return true
+}
+
+// parseKeyTokens 把 fmt 格式转换成字符序列，%d 是一个或多个数字，其他占位符是一个或多个任意字符
+func parseKeyTokens(format string) []keyToken {
+	var tokens []keyToken
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			tokens = append(tokens, keyToken{c: format[i]})
+			continue
+		}
+		i++
+		if format[i] == '%' {
+			tokens = append(tokens, keyToken{c: '%'})
+			continue
+		}
+		// 跳过 %05d 这种宽度和标记
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		digit := i < len(format) && format[i] == 'd'
+		tokens = append(tokens, keyToken{digit: digit}, keyToken{digit: digit, star: true})
+	}
+	return tokens
+}
+
+// intersectKeys 同时遍历两个格式，找到两边都能生成的最短的key
+func intersectKeys(a, b []keyToken) (string, bool) {
+	// 字面字符和一个代表数字的字符足以覆盖所有的匹配情况
+	alphabet := map[byte]bool{'1': true}
+	for _, tokens := range [][]keyToken{a, b} {
+		for _, t := range tokens {
+			if t.c != 0 {
+				alphabet[t.c] = true
+			}
+		}
+	}
+	chars := make([]byte, 0, len(alphabet))
+	for c := range alphabet {
+		chars = append(chars, c)
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+
+	type state struct{ i, j int }
+	type step struct {
+		from state
+		c    byte
+	}
+	start := state{}
+	prev := map[state]step{}
+	seen := map[state]bool{start: true}
+	queue := []state{start}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		ca, cb := tokenClosure(a, s.i), tokenClosure(b, s.j)
+		if ca[len(ca)-1] == len(a) && cb[len(cb)-1] == len(b) {
+			var key []byte
+			for s != start {
+				key = append([]byte{prev[s].c}, key...)
+				s = prev[s].from
+			}
+			return string(key), true
+		}
+		for _, c := range chars {
+			for _, i := range ca {
+				ni, ok := tokenNext(a, i, c)
+				if !ok {
+					continue
+				}
+				for _, j := range cb {
+					nj, ok := tokenNext(b, j, c)
+					if !ok {
+						continue
+					}
+					if n := (state{ni, nj}); !seen[n] {
+						seen[n] = true
+						prev[n] = step{from: s, c: c}
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// tokenClosure 从位置 i 开始可以跳过重复0次的字符到达的位置，按从小到大排列
+func tokenClosure(tokens []keyToken, i int) []int {
+	positions := []int{i}
+	for i < len(tokens) && tokens[i].star {
+		i++
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// tokenNext 在位置 i 读取字符 c 之后的位置
+func tokenNext(tokens []keyToken, i int, c byte) (int, bool) {
+	if i >= len(tokens) || !tokens[i].match(c) {
+		return 0, false
+	}
+	if tokens[i].star {
+		return i, true
+	}
+	return i + 1, true
+}
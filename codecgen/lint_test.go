@@ -0,0 +1,79 @@
+package codecgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyTokens(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []keyToken
+	}{
+		{"a", []keyToken{{c: 'a'}}},
+		{"a%d", []keyToken{{c: 'a'}, {digit: true}, {digit: true, star: true}}},
+		{"%05d", []keyToken{{digit: true}, {digit: true, star: true}}},
+		{"%s.", []keyToken{{}, {star: true}, {c: '.'}}},
+		{"100%%", []keyToken{{c: '1'}, {c: '0'}, {c: '0'}, {c: '%'}}},
+		{"a%", []keyToken{{c: 'a'}, {c: '%'}}},
+	}
+	for _, tt := range tests {
+		if got := parseKeyTokens(tt.format); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeyTokens(%q) = %+v, want %+v", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestTokenClosure(t *testing.T) {
+	tokens := parseKeyTokens("a%d%sb")
+	tests := []struct {
+		i    int
+		want []int
+	}{
+		{0, []int{0}},
+		{1, []int{1}},
+		{2, []int{2, 3}},
+		{4, []int{4, 5}},
+		{5, []int{5}},
+		{len(tokens), []int{len(tokens)}},
+	}
+	for _, tt := range tests {
+		if got := tokenClosure(tokens, tt.i); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenClosure(a%%d%%sb, %d) = %v, want %v", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestIntersectKeys(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string // 为空表示没有相同的key
+	}{
+		{"table.key.user.%d", "table.key.user.%d", "table.key.user.1"},
+		{"table.key.user.%d", "table.key.room.%d", ""},
+		{"table.key.user.%d", "table.key.user.%d" + notFoundSuffix, ""},
+		{"table.key.user.%d" + notFoundSuffix, "table.key.user.%s", "table.key.user.1.nil"},
+		{"table.key.user.%d", "table.key.user.1%d", "table.key.user.11"},
+		{"table.key.user.%d", "table.key.user.%s", "table.key.user.1"},
+		{"u%d.%d", "u%d", ""},
+		{"u%s", "u%d.x", "u1.x"},
+		{"%s", "", ""},
+	}
+	for _, tt := range tests {
+		got, ok := intersectKeys(parseKeyTokens(tt.a), parseKeyTokens(tt.b))
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("intersectKeys(%q, %q) = %q, %v, want %q", tt.a, tt.b, got, ok, tt.want)
+		}
+		if !ok {
+			continue
+		}
+		// 找到的key两边都能生成，交换参数结果相同
+		if again, _ := intersectKeys(parseKeyTokens(tt.b), parseKeyTokens(tt.a)); again != got {
+			t.Errorf("intersectKeys(%q, %q) = %q, want %q", tt.b, tt.a, again, got)
+		}
+		if !strings.HasPrefix(got, keyPrefix(tt.a)) || !strings.HasPrefix(got, keyPrefix(tt.b)) {
+			t.Errorf("intersectKeys(%q, %q) = %q, not matching both prefixes", tt.a, tt.b, got)
+		}
+	}
+}
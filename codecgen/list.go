@@ -0,0 +1,84 @@
+package codecgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// newListData 校验 -list 的参数并生成列表codec的模板数据。
+// 列表codec缓存 <list> 列的值到唯一键列表的映射，数据本身仍通过 <table>_codec.go 读取
+func newListData(cfg Config, pkg string, seconds int64) (*codecData, error) {
+	column, key := strings.ToLower(cfg.List), strings.ToLower(cfg.UniqueKey)
+	if column == key {
+		return nil, fmt.Errorf("-list %s 不能和唯一键 -uq 相同", cfg.List)
+	}
+	switch {
+	case len(cfg.Fields) > 0 || len(cfg.Exclude) > 0:
+		return nil, fmt.Errorf("-list 只缓存唯一键列表，不支持 -fields/-exclude")
+	case cfg.NotFound:
+		return nil, fmt.Errorf("-list 会缓存空列表，不需要 -notfound")
+	case cfg.TtlHook:
+		return nil, fmt.Errorf("-list 不支持 -ttl-hook")
+	case cfg.Tier != "" && cfg.Tier != TierRedis:
+		return nil, fmt.Errorf("-list 只支持 -tier redis")
+	case cfg.Test:
+		return nil, fmt.Errorf("-list 暂不支持 -test")
+	case cfg.Encoding != "" && cfg.Encoding != EncodingProtobuf:
+		return nil, fmt.Errorf("-list 只缓存唯一键列表，不支持 -encoding")
+	case cfg.Layout != "" && cfg.Layout != LayoutString:
+		return nil, fmt.Errorf("-list 只缓存唯一键列表，不支持 -layout")
+	case cfg.Chunk > 0 || cfg.LoadTimeout > 0 || cfg.SingleFlight:
+		return nil, fmt.Errorf("-list 不支持 -chunk/-load-timeout/-singleflight")
+	case cfg.ShardCount > 0:
+		// 列表按 <list> 列查询，不带唯一键，无法路由到分表，也没有 dao.<PbName>
+		return nil, fmt.Errorf("-list 不支持 -shards")
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 100 {
+		return nil, fmt.Errorf("-jitter 必须在 0~100 之间")
+	}
+
+	pbName := FirstUppers(cfg.TableName)
+	if !cfg.SkipCheck {
+		if err := verifyDao(pbName); err != nil {
+			return nil, err
+		}
+	}
+	schema, err := LoadSchema(cfg.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if schema != nil {
+		for _, c := range []string{column, key} {
+			if !schema.Has(c) {
+				return nil, fmt.Errorf("表 %s 中不存在列 %s (表结构来自 %s)", cfg.TableName, c, schema.Source)
+			}
+		}
+	}
+	wheres, err := conditions(cfg, schema)
+	if err != nil {
+		return nil, err
+	}
+	keyFormat, err := KeyFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	listName := pbName + "By" + FirstUppers(column)
+	return &codecData{
+		Package:      pkg,
+		Module:       cfg.Module,
+		Table:        cfg.TableName,
+		PbName:       pbName,
+		LowerName:    FirstLower(listName),
+		KeyColumn:    key,
+		KeyFormat:    keyFormat,
+		RedisDb:      "Redis" + FirstUppers(cfg.RedisDb),
+		TtlSeconds:   seconds,
+		Query:        wheres,
+		Jitter:       cfg.Jitter,
+		ListColumn:   column,
+		ListName:     listName,
+		Metrics:      cfg.Metrics,
+		MetricsLabel: cfg.TableName + "." + column,
+	}, nil
+}
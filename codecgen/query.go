@@ -0,0 +1,118 @@
+package codecgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SoftDeleteAuto = "auto" // 根据表结构识别软删除列
+	SoftDeleteOff  = "off"  // 不加软删除条件
+)
+
+// softDeleteColumns 约定的软删除列，值为0表示未删除。
+// delete_at 这类时间列由 gf 的 ORM 自动过滤，这里不需要处理
+var softDeleteColumns = []string{"deleted", "is_deleted", "is_del", "del_flag"}
+
+// SplitList 解析逗号分隔的参数，忽略空白项
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// projection 生成 One/FindAll 查询的列裁剪，返回形如 .Fields("id,name") 的调用
+func projection(cfg Config, schema *TableSchema) (string, error) {
+	if len(cfg.Fields) > 0 && len(cfg.Exclude) > 0 {
+		return "", fmt.Errorf("-fields 和 -exclude 不能同时使用")
+	}
+	key := strings.ToLower(cfg.UniqueKey)
+	if schema != nil {
+		for _, column := range append(append([]string{key}, cfg.Fields...), cfg.Exclude...) {
+			if !schema.Has(column) {
+				return "", fmt.Errorf("表 %s 中不存在列 %s (表结构来自 %s)", cfg.TableName, column, schema.Source)
+			}
+		}
+	}
+
+	if len(cfg.Fields) > 0 {
+		fields := cfg.Fields
+		if !containsString(fields, key) {
+			// FindAll 需要通过唯一键把数据对应回缓存key
+			fields = append([]string{key}, fields...)
+		}
+		return fmt.Sprintf(".Fields(%q)", strings.Join(fields, ",")), nil
+	}
+	if len(cfg.Exclude) > 0 {
+		if containsString(cfg.Exclude, key) {
+			return "", fmt.Errorf("-exclude 不能排除唯一键 %s", key)
+		}
+		return fmt.Sprintf(".FieldsEx(%q)", strings.Join(cfg.Exclude, ",")), nil
+	}
+	return "", nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// conditions 生成 One/FindAll 查询额外的静态条件，返回形如 .Where("deleted = 0") 的调用
+func conditions(cfg Config, schema *TableSchema) (string, error) {
+	var wheres []string
+	for _, where := range cfg.Where {
+		where = strings.TrimSpace(where)
+		if where == "" {
+			continue
+		}
+		if strings.Contains(where, "?") {
+			return "", fmt.Errorf("-where %q 只支持静态条件，不能包含占位符?", where)
+		}
+		wheres = append(wheres, where)
+	}
+
+	switch cfg.SoftDelete {
+	case "", SoftDeleteAuto:
+		if schema == nil {
+			break
+		}
+		for _, column := range softDeleteColumns {
+			if !schema.Has(column) || mentionsColumn(wheres, column) {
+				continue
+			}
+			fmt.Printf("检测到软删除列 %s，缓存查询将加上条件 %s = 0，可用 -softdelete=off 关闭\n", column, column)
+			wheres = append(wheres, column+" = 0")
+		}
+	case SoftDeleteOff:
+	default:
+		return "", fmt.Errorf("未知的 -softdelete %q，可选 auto,off", cfg.SoftDelete)
+	}
+
+	var b strings.Builder
+	for _, where := range wheres {
+		fmt.Fprintf(&b, ".Where(%q)", where)
+	}
+	return b.String(), nil
+}
+
+// mentionsColumn 用户已经在 -where 中指定了该列的条件
+func mentionsColumn(wheres []string, column string) bool {
+	for _, where := range wheres {
+		for _, word := range strings.FieldsFunc(where, func(r rune) bool {
+			return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		}) {
+			if strings.EqualFold(word, column) {
+				return true
+			}
+		}
+	}
+	return false
+}
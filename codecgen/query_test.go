@@ -0,0 +1,114 @@
+package codecgen
+
+import (
+	"strings"
+	"testing"
+)
+
+var userInfoSchema = &TableSchema{Table: "user_info", Source: "user_info.go", Columns: []string{"id", "uid", "name", "description", "deleted"}}
+
+func TestProjection(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		exclude []string
+		schema  *TableSchema
+		want    string
+		wantErr string
+	}{
+		{name: "all columns", schema: userInfoSchema, want: ""},
+		{name: "fields", fields: []string{"name"}, schema: userInfoSchema, want: `.Fields("uid,name")`},
+		{name: "fields with key", fields: []string{"name", "uid"}, schema: userInfoSchema, want: `.Fields("name,uid")`},
+		{name: "exclude", exclude: []string{"description"}, schema: userInfoSchema, want: `.FieldsEx("description")`},
+		{name: "without schema", fields: []string{"nickname"}, want: `.Fields("uid,nickname")`},
+		{name: "unknown column", fields: []string{"nickname"}, schema: userInfoSchema, wantErr: "表 user_info 中不存在列 nickname"},
+		{name: "exclude key", exclude: []string{"uid"}, schema: userInfoSchema, wantErr: "-exclude 不能排除唯一键 uid"},
+		{name: "both", fields: []string{"name"}, exclude: []string{"description"}, wantErr: "-fields 和 -exclude 不能同时使用"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{TableName: "user_info", UniqueKey: "uid", Fields: tt.fields, Exclude: tt.exclude}
+			got, err := projection(cfg, tt.schema)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("projection() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("projection() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectedQueries(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "fields",
+			edit: func(cfg *Config) { cfg.Fields = []string{"name"} },
+			contains: []string{
+				`.Where("uid = ?", key).Fields("uid,name").Struct(data)`,
+				`.Where("uid in (?)", keys).Fields("uid,name").FindAll()`,
+			},
+		},
+		{
+			name:     "json exclude",
+			edit:     func(cfg *Config) { cfg.Encoding, cfg.Exclude = EncodingJSON, []string{"description"} },
+			contains: []string{`.Where("uid in (?)", keys).FieldsEx("description").Structs(&list)`},
+		},
+	})
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		where      []string
+		softDelete string
+		schema     *TableSchema
+		want       string
+		wantErr    string
+	}{
+		{name: "soft delete", schema: userInfoSchema, want: `.Where("deleted = 0")`},
+		{name: "soft delete off", softDelete: SoftDeleteOff, schema: userInfoSchema, want: ""},
+		{name: "without schema", want: ""},
+		{name: "where", where: []string{" status = 1 ", ""}, schema: userInfoSchema, want: `.Where("status = 1").Where("deleted = 0")`},
+		// 已经在 -where 中指定了软删除列的条件，不再自动添加
+		{name: "where soft delete column", where: []string{"Deleted IN (0,2)"}, schema: userInfoSchema, want: `.Where("Deleted IN (0,2)")`},
+		{name: "column prefix", where: []string{"deleted_by = 0"}, schema: userInfoSchema, want: `.Where("deleted_by = 0").Where("deleted = 0")`},
+		{name: "placeholder", where: []string{"status = ?"}, wantErr: "只支持静态条件"},
+		{name: "unknown soft delete", softDelete: "on", wantErr: `未知的 -softdelete "on"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{TableName: "user_info", UniqueKey: "uid", Where: tt.where, SoftDelete: tt.softDelete}
+			got, err := conditions(cfg, tt.schema)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("conditions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("conditions() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilteredQueries(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name:     "where before projection",
+			edit:     func(cfg *Config) { cfg.Where, cfg.Fields = []string{"status = 1"}, []string{"name"} },
+			contains: []string{`.Where("uid = ?", key).Where("status = 1").Fields("uid,name").Struct(data)`},
+		},
+		{
+			// 预热遍历唯一键时只需要条件，不需要列裁剪
+			name:     "warmup",
+			edit:     func(cfg *Config) { cfg.Where, cfg.Fields, cfg.WarmUp = []string{"status = 1"}, []string{"name"}, true },
+			file:     "user_info" + warmUpSuffix,
+			contains: []string{`Fields("uid").Where("uid > ?", last).Where("status = 1").Order("uid")`},
+		},
+	})
+}
@@ -0,0 +1,502 @@
+package codecgen
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Overwrite 已存在的codec文件的覆盖策略
+type Overwrite string
+
+const (
+	OverwriteSkip   Overwrite = "skip"   // 文件已存在时跳过
+	OverwriteForce  Overwrite = "force"  // 直接覆盖，手动修改会丢失
+	OverwritePrompt Overwrite = "prompt" // 文件已存在时询问
+	OverwriteMerge  Overwrite = "merge"  // 重新生成，保留手动修改过的区域
+)
+
+// ParseOverwrite 解析-overwrite参数
+func ParseOverwrite(s string) (Overwrite, error) {
+	switch o := Overwrite(s); o {
+	case OverwriteSkip, OverwriteForce, OverwritePrompt, OverwriteMerge:
+		return o, nil
+	case "":
+		return OverwriteMerge, nil
+	}
+	return "", fmt.Errorf("未知的覆盖策略 %q，可选 skip,force,prompt,merge", s)
+}
+
+const (
+	regionBegin   = "// slpctl:begin "
+	regionEnd     = "// slpctl:end "
+	regionSum     = " sum="
+	regionOutside = "// slpctl:outside sum=" // 区域外代码的校验和，每段一个，逗号分隔
+)
+
+// region 生成代码中由 slpctl:begin/end 包围的区域
+type region struct {
+	Name string
+	Sum  string // 生成时记录的校验和
+	Body string // begin/end 之间的内容
+}
+
+// Edited 区域内容与生成时的校验和不一致，说明被手动修改过
+func (r region) Edited() bool {
+	return r.Sum != "" && r.Sum != regionChecksum(r.Body)
+}
+
+// regionChecksum 忽略空白字符计算校验和，gofmt 的格式调整不会被当作手动修改
+func regionChecksum(body string) string {
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, body)
+	sum := sha1.Sum([]byte(stripped))
+	return hex.EncodeToString(sum[:8])
+}
+
+// scanRegions 按行扫描区域，fn 返回 begin 行的替换内容和区域内容
+func scanRegions(src string, fn func(r region) (string, string)) (string, error) {
+	var out strings.Builder
+	lines := strings.SplitAfter(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, regionBegin) {
+			out.WriteString(lines[i])
+			continue
+		}
+		r := region{Name: strings.TrimPrefix(line, regionBegin)}
+		if idx := strings.Index(r.Name, regionSum); idx >= 0 {
+			r.Sum = r.Name[idx+len(regionSum):]
+			r.Name = r.Name[:idx]
+		}
+		var body strings.Builder
+		end := -1
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == regionEnd+r.Name {
+				end = j
+				break
+			}
+			body.WriteString(lines[j])
+		}
+		if end < 0 {
+			return "", fmt.Errorf("区域 %s 缺少结束标记", r.Name)
+		}
+		r.Body = body.String()
+		head, content := fn(r)
+		out.WriteString(head)
+		out.WriteString(content)
+		out.WriteString(lines[end])
+		i = end
+	}
+	return out.String(), nil
+}
+
+// sealRegions 给生成代码的每个区域写入校验和
+func sealRegions(src string) (string, error) {
+	return scanRegions(src, func(r region) (string, string) {
+		return regionBegin + r.Name + regionSum + regionChecksum(r.Body) + "\n", r.Body
+	})
+}
+
+// parseRegions 读取已存在文件中的区域
+func parseRegions(src string) (map[string]region, error) {
+	regions := make(map[string]region)
+	_, err := scanRegions(src, func(r region) (string, string) {
+		regions[r.Name] = r
+		return "", ""
+	})
+	return regions, err
+}
+
+// outside 区域外的代码，按区域分成若干段
+type outside struct {
+	Gaps  []string // Gaps[0] 是第一个区域之前的代码，Gaps[i] 是第 i 个区域之后的代码
+	Names []string // 区域名，Names[i-1] 是 Gaps[i] 前面的区域
+	Sums  []string // 文件中记录的每段的校验和，没有记录时为 nil
+}
+
+// parseOutside 读取区域外的代码和记录的校验和
+func parseOutside(src string) (outside, error) {
+	var o outside
+	var gap strings.Builder
+	lines := strings.SplitAfter(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case strings.HasPrefix(line, regionOutside):
+			o.Sums = strings.Split(strings.TrimPrefix(line, regionOutside), ",")
+		case strings.HasPrefix(line, regionBegin):
+			name := strings.TrimPrefix(line, regionBegin)
+			if idx := strings.Index(name, regionSum); idx >= 0 {
+				name = name[:idx]
+			}
+			end := i + 1
+			for end < len(lines) && strings.TrimSpace(lines[end]) != regionEnd+name {
+				end++
+			}
+			if end == len(lines) {
+				return o, fmt.Errorf("区域 %s 缺少结束标记", name)
+			}
+			o.Gaps = append(o.Gaps, gap.String())
+			o.Names = append(o.Names, name)
+			gap.Reset()
+			i = end
+		default:
+			gap.WriteString(lines[i])
+		}
+	}
+	o.Gaps = append(o.Gaps, gap.String())
+	return o, nil
+}
+
+// Edited 返回校验和不一致的区域外代码的位置，没有记录校验和时返回 nil
+func (o outside) Edited() []string {
+	if o.Sums == nil {
+		return nil
+	}
+	if len(o.Sums) != len(o.Gaps) {
+		return []string{"区域标记被增删"}
+	}
+	var edited []string
+	for i, gap := range o.Gaps {
+		if o.Sums[i] == regionChecksum(gap) {
+			continue
+		}
+		if i == 0 {
+			edited = append(edited, "第一个区域之前")
+		} else {
+			edited = append(edited, "区域 "+o.Names[i-1]+" 之后")
+		}
+	}
+	return edited
+}
+
+// sealOutside 在文件末尾记录区域外代码的校验和，用于检测区域外的手动修改，没有区域的文件不记录
+func sealOutside(src string) (string, error) {
+	o, err := parseOutside(src)
+	if err != nil || len(o.Names) == 0 {
+		return src, err
+	}
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(src, "\n") {
+		// 去掉旧的校验和
+		if !strings.HasPrefix(strings.TrimSpace(line), regionOutside) {
+			out.WriteString(line)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	sums := make([]string, len(o.Gaps))
+	for i, gap := range o.Gaps {
+		sums[i] = regionChecksum(gap)
+	}
+	out.WriteString(regionOutside + strings.Join(sums, ",") + "\n")
+	return out.String(), nil
+}
+
+// funcDecl 匹配区域中声明的函数和方法名
+var funcDecl = regexp.MustCompile(`(?m)^func\s+(?:\([^)]*\)\s*)?(\w+)`)
+
+// declaredFuncs 区域中声明的函数名，按名字排序后用逗号连接
+func declaredFuncs(body string) string {
+	var names []string
+	for _, m := range funcDecl.FindAllStringSubmatch(body, -1) {
+		names = append(names, m[1])
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// mergeRegions 用旧文件中手动修改过的区域替换新生成的区域，返回被保留的区域名。
+// 手动修改过的区域在新模板中不存在，或者声明的函数和新模板不一致时（如 -metrics 把 One 改名为 one）
+// 合并会丢失修改或者重复声明函数，返回错误
+func mergeRegions(src string, old map[string]region) (string, []string, error) {
+	var kept, conflicts []string
+	seen := make(map[string]bool)
+	merged, err := scanRegions(src, func(r region) (string, string) {
+		seen[r.Name] = true
+		o, ok := old[r.Name]
+		if !ok || !o.Edited() {
+			return regionBegin + r.Name + regionSum + r.Sum + "\n", r.Body
+		}
+		if got, want := declaredFuncs(o.Body), declaredFuncs(r.Body); got != want {
+			conflicts = append(conflicts, fmt.Sprintf("区域 %s 中声明的函数 [%s] 与新模板的 [%s] 不一致", r.Name, got, want))
+			return regionBegin + r.Name + regionSum + r.Sum + "\n", r.Body
+		}
+		kept = append(kept, r.Name)
+		return regionBegin + o.Name + regionSum + o.Sum + "\n", o.Body
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	for _, name := range editedRegions(old) {
+		if !seen[name] {
+			conflicts = append(conflicts, fmt.Sprintf("区域 %s 在新模板中已经不存在", name))
+		}
+	}
+	if len(conflicts) > 0 {
+		return "", nil, fmt.Errorf("手动修改的区域无法合并: %s", strings.Join(conflicts, "; "))
+	}
+	return merged, kept, nil
+}
+
+// editedRegions 返回被手动修改过的区域名
+func editedRegions(regions map[string]region) []string {
+	var names []string
+	for _, r := range regions {
+		if r.Edited() {
+			names = append(names, r.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveOverwrite 根据覆盖策略决定写入的内容，返回 false 表示不写入
+func resolveOverwrite(policy Overwrite, filePath, content string) (string, bool, error) {
+	b, err := PathExists(filePath)
+	if err != nil {
+		return "", false, err
+	}
+	if !b {
+		return content, true, nil
+	}
+	if policy == OverwriteSkip {
+		fmt.Printf("文件已存在，跳过生成: %s\n", filePath)
+		return "", false, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", false, fmt.Errorf("读取已存在的文件 %s 失败: %v", filePath, err)
+	}
+	old, err := parseRegions(string(data))
+	if err != nil {
+		return "", false, fmt.Errorf("解析已存在的文件 %s 失败: %v", filePath, err)
+	}
+	out, err := parseOutside(string(data))
+	if err != nil {
+		return "", false, fmt.Errorf("解析已存在的文件 %s 失败: %v", filePath, err)
+	}
+	edited, outsideEdited := editedRegions(old), out.Edited()
+	legacy := len(old) == 0
+	// 之前版本生成的文件有区域标记但没有区域外的校验和，无法检测区域外的修改
+	unsealed := !legacy && out.Sums == nil
+
+	if policy == OverwritePrompt {
+		policy = promptOverwrite(filePath, edited, outsideEdited, legacy)
+		if policy == OverwriteSkip {
+			fmt.Printf("跳过生成: %s\n", filePath)
+			return "", false, nil
+		}
+	}
+
+	switch {
+	case policy == OverwriteForce:
+		if len(edited) > 0 {
+			fmt.Printf("警告: 覆盖 %s 中手动修改过的区域 %v\n", filePath, edited)
+		}
+		if len(outsideEdited) > 0 {
+			fmt.Printf("警告: 覆盖 %s 中区域外的手动修改 %v\n", filePath, outsideEdited)
+		}
+		return content, true, nil
+	case legacy:
+		// 旧版本生成的文件没有区域标记，无法检测手动修改，先备份
+		backup, err := backupFile(filePath, data)
+		if err != nil {
+			return "", false, err
+		}
+		fmt.Printf("警告: %s 没有生成标记，无法检测手动修改，原文件已备份到 %s\n", filePath, backup)
+		return content, true, nil
+	case len(outsideEdited) > 0:
+		return "", false, fmt.Errorf("%s 中区域外的代码被手动修改过 %v，merge 只保留 slpctl:begin/end 区域内的修改；"+
+			"请把修改移到区域内或单独的文件，或使用 -overwrite force 覆盖", filePath, outsideEdited)
+	case unsealed:
+		backup, err := backupFile(filePath, data)
+		if err != nil {
+			return "", false, err
+		}
+		fmt.Printf("警告: %s 没有记录区域外代码的校验和，无法检测区域外的手动修改，原文件已备份到 %s\n", filePath, backup)
+	}
+
+	merged, kept, err := mergeRegions(content, old)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v，请把修改迁移到新的区域后重试，或使用 -overwrite force 覆盖", filePath, err)
+	}
+	if len(kept) > 0 {
+		if err = checkImports(filePath, merged); err != nil {
+			return "", false, fmt.Errorf("保留 %s 中手动修改过的区域 %v 后无法编译: %v，请修改这些区域或使用 -overwrite force 覆盖",
+				filePath, kept, err)
+		}
+		fmt.Printf("警告: 保留了 %s 中手动修改过的区域 %v，请确认与新模板是否兼容\n", filePath, kept)
+	}
+	return merged, true, nil
+}
+
+func backupFile(filePath string, data []byte) (string, error) {
+	backup := filePath + ".bak"
+	if err := os.WriteFile(backup, data, 0644); err != nil {
+		return "", fmt.Errorf("备份文件 %s 失败: %v", backup, err)
+	}
+	return backup, nil
+}
+
+func promptOverwrite(filePath string, edited, outsideEdited []string, legacy bool) Overwrite {
+	switch {
+	case legacy:
+		fmt.Printf("文件 %s 已存在且没有生成标记，无法检测手动修改\n", filePath)
+	case len(edited) > 0 || len(outsideEdited) > 0:
+		if len(edited) > 0 {
+			fmt.Printf("文件 %s 已存在，以下区域被手动修改过: %v\n", filePath, edited)
+		}
+		if len(outsideEdited) > 0 {
+			fmt.Printf("文件 %s 已存在，区域外的代码被手动修改过，合并时不会保留: %v\n", filePath, outsideEdited)
+		}
+	default:
+		fmt.Printf("文件 %s 已存在，没有检测到手动修改\n", filePath)
+	}
+	fmt.Print("覆盖(y) / 合并保留手动修改(m) / 跳过(N)? ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return OverwriteSkip
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return OverwriteForce
+	case "m", "merge":
+		return OverwriteMerge
+	}
+	return OverwriteSkip
+}
+
+// checkImports 检查合并后的文件中包的引用：保留的区域可能使用了新模板没有导入的包，
+// 新模板导入的包也可能只在被替换掉的区域中使用
+func checkImports(filePath, src string) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filePath, src, 0)
+	if err != nil {
+		return err
+	}
+	imported := make(map[string]bool) // 包名 -> 是否能确定包名
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name, exact := importName(path)
+		if spec.Name != nil {
+			name, exact = spec.Name.Name, true
+		}
+		if name != "_" && name != "." {
+			imported[name] = exact
+		}
+	}
+	decls, err := packageDecls(filePath, file.Name.Name)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	var issues []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || id.Obj != nil {
+			return true
+		}
+		if _, ok := imported[id.Name]; ok {
+			used[id.Name] = true
+		} else if !decls[id.Name] && !used[id.Name] {
+			used[id.Name] = true
+			issues = append(issues, fmt.Sprintf("%s: 未导入的包 %s", fset.Position(id.Pos()), id.Name))
+		}
+		return true
+	})
+	for name, exact := range imported {
+		if exact && !used[name] {
+			issues = append(issues, fmt.Sprintf("导入了没有使用的包 %s", name))
+		}
+	}
+	if len(issues) > 0 {
+		sort.Strings(issues)
+		return fmt.Errorf("%s", strings.Join(issues, "; "))
+	}
+	return nil
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importName 按导入路径推断包名，路径最后一段不是合法标识符时只能猜测，第二个返回值为 false
+func importName(path string) (string, bool) {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	if token.IsIdentifier(name) {
+		return name, true
+	}
+	// gopkg.in/yaml.v2、go-sqlmock、redis-go 这类路径
+	if idx := strings.Index(name, ".v"); idx > 0 {
+		name = name[:idx]
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "go-"), "-go")
+	if idx := strings.LastIndex(name, "-"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name, false
+}
+
+// packageDecls 同一个包中其他文件的顶层声明，测试文件可以使用非测试文件的声明，反之不行
+func packageDecls(filePath, pkg string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(filePath), "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	test := strings.HasSuffix(filePath, "_test.go")
+	decls := make(map[string]bool)
+	for _, path := range files {
+		if filepath.Clean(path) == filepath.Clean(filePath) || (!test && strings.HasSuffix(path, "_test.go")) {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name != pkg {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					decls[d.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						decls[s.Name.Name] = true
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							decls[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls, nil
+}
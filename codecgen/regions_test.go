@@ -0,0 +1,301 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const regionSrc = `package codec
+
+import "fmt"
+
+// slpctl:begin One
+func (b codec) One() string {
+	return fmt.Sprint(1)
+}
+// slpctl:end One
+
+func helper() {}
+
+// slpctl:begin Two
+func (b codec) Two() {}
+// slpctl:end Two
+`
+
+func TestRegionChecksum(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"return 1\n", "return 1\n", true},
+		{"return 1\n", "\treturn  1\n\n", true},
+		{"return 1\n", "return 2\n", false},
+		{"a b", "ab", true},
+		{"", "\n\t ", true},
+	}
+	for _, tt := range tests {
+		if got := regionChecksum(tt.a) == regionChecksum(tt.b); got != tt.same {
+			t.Errorf("regionChecksum(%q) == regionChecksum(%q) = %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
+
+func TestSealRegions(t *testing.T) {
+	sealed, err := sealRegions(regionSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regions, err := parseRegions(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 2 {
+		t.Fatalf("parseRegions() = %d regions, want 2", len(regions))
+	}
+	for name, r := range regions {
+		if r.Sum == "" || r.Edited() {
+			t.Errorf("region %s: sum %q, edited %v", name, r.Sum, r.Edited())
+		}
+	}
+	if got := editedRegions(regions); len(got) != 0 {
+		t.Errorf("editedRegions() = %v, want none", got)
+	}
+
+	if _, err = sealRegions("// slpctl:begin One\nfunc One() {}\n"); err == nil {
+		t.Error("sealRegions() without end marker: want error")
+	}
+}
+
+func TestMergeRegions(t *testing.T) {
+	sealed, err := sealRegions(regionSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		edit    [2]string // 对 sealed 做的替换，模拟手动修改
+		new     string
+		kept    []string
+		wantErr string
+	}{
+		{
+			name: "unchanged",
+			new:  sealed,
+		},
+		{
+			name: "keep edited region",
+			edit: [2]string{"fmt.Sprint(1)", "fmt.Sprint(2)"},
+			new:  sealed,
+			kept: []string{"One"},
+		},
+		{
+			name: "whitespace only",
+			edit: [2]string{"\treturn fmt.Sprint(1)", "\t\treturn   fmt.Sprint(1)"},
+			new:  sealed,
+		},
+		{
+			name:    "function renamed",
+			edit:    [2]string{"fmt.Sprint(1)", "fmt.Sprint(2)"},
+			new:     strings.Replace(sealed, "One() string", "one() string", 1),
+			wantErr: "[One] 与新模板的 [one]",
+		},
+		{
+			name:    "region dropped",
+			edit:    [2]string{"Two() {}", "Two() { panic(2) }"},
+			new:     sealed[:strings.Index(sealed, "// slpctl:begin Two")],
+			wantErr: "区域 Two 在新模板中已经不存在",
+		},
+		{
+			name: "unedited region dropped",
+			new:  sealed[:strings.Index(sealed, "// slpctl:begin Two")],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := sealed
+			if tt.edit[0] != "" {
+				src = strings.Replace(sealed, tt.edit[0], tt.edit[1], 1)
+			}
+			old, err := parseRegions(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			merged, kept, err := mergeRegions(tt.new, old)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mergeRegions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(kept, ",") != strings.Join(tt.kept, ",") {
+				t.Errorf("mergeRegions() kept = %v, want %v", kept, tt.kept)
+			}
+			if len(tt.kept) > 0 && !strings.Contains(merged, tt.edit[1]) {
+				t.Error("mergeRegions() lost the edited region")
+			}
+			if len(tt.kept) == 0 && merged != tt.new {
+				t.Errorf("mergeRegions() changed the new source:\n%s", merged)
+			}
+		})
+	}
+}
+
+func TestOutsideEdited(t *testing.T) {
+	sealed, err := sealRegions(regionSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err = sealOutside(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := sealOutside(sealed); again != sealed {
+		t.Errorf("sealOutside() is not idempotent:\n%s", again)
+	}
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"unchanged", sealed, nil},
+		{"reformatted", strings.Replace(sealed, "func helper() {}", "func  helper()  {\n}", 1), nil},
+		{"region edited", strings.Replace(sealed, "fmt.Sprint(1)", "fmt.Sprint(2)", 1), nil},
+		{"before first region", strings.Replace(sealed, `import "fmt"`, `import "strings"`, 1), []string{"第一个区域之前"}},
+		{"between regions", strings.Replace(sealed, "func helper() {}", "func helper() { panic(1) }", 1), []string{"区域 One 之后"}},
+		{"appended", sealed + "\nfunc extra() {}\n", []string{"区域 Two 之后"}},
+		{"not sealed", regionSrc + "\nfunc extra() {}\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := parseOutside(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := o.Edited(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Edited() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportName(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		exact bool
+	}{
+		{"fmt", "fmt", true},
+		{"encoding/json", "json", true},
+		{"github.com/go-redis/redis/v8", "redis", true},
+		{"github.com/DATA-DOG/go-sqlmock", "sqlmock", false},
+		{"gopkg.in/yaml.v2", "yaml", false},
+	}
+	for _, tt := range tests {
+		if name, exact := importName(tt.path); name != tt.name || exact != tt.exact {
+			t.Errorf("importName(%q) = %q, %v, want %q, %v", tt.path, name, exact, tt.name, tt.exact)
+		}
+	}
+}
+
+func TestCheckImports(t *testing.T) {
+	dir := t.TempDir()
+	other := "package codec\n\ntype codec struct{}\n\nvar Shared = map[string]int{}\n"
+	if err := os.WriteFile(filepath.Join(dir, "other.go"), []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name: "ok",
+			src:  "package codec\n\nimport \"fmt\"\n\nfunc (b codec) One() string { return fmt.Sprint(Shared) }\n",
+		},
+		{
+			name: "local and package names",
+			src:  "package codec\n\nfunc One() int { s := Shared; return len(s) }\n\nfunc Two(b codec) {}\n",
+		},
+		{
+			name:    "missing import",
+			src:     "package codec\n\nfunc One() string { return strconv.Itoa(1) }\n",
+			wantErr: "未导入的包 strconv",
+		},
+		{
+			name:    "unused import",
+			src:     "package codec\n\nimport \"strconv\"\n\nfunc One() {}\n",
+			wantErr: "没有使用的包 strconv",
+		},
+		{
+			name: "guessed name not reported as unused",
+			src:  "package codec\n\nimport \"github.com/DATA-DOG/go-sqlmock\"\n\nfunc One() {}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkImports(filepath.Join(dir, "user_codec.go"), tt.src)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkImports() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkImports() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodecExecWritesNothingOnConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(cfg *Config, codec string) string
+	}{
+		{"outside edited", func(cfg *Config, codec string) string {
+			cfg.Overwrite = OverwriteMerge
+			return codec + "\nfunc extra() {}\n"
+		}},
+		{"warmup outside project", func(cfg *Config, codec string) string {
+			// 临时目录是绝对路径，无法确定 codec 包的import路径
+			cfg.WarmUp = true
+			return codec
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := testCodecConfig(dir)
+			if err := CodecExec(cfg); err != nil {
+				t.Fatal(err)
+			}
+			codecPath, supportPath := filepath.Join(dir, "user_info_codec.go"), filepath.Join(dir, supportFile)
+			content, err := os.ReadFile(codecPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec := tt.edit(&cfg, string(content))
+			const support = "package codec\n"
+			for path, content := range map[string]string{codecPath: codec, supportPath: support} {
+				if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg.Seconds = 120
+			if err = CodecExec(cfg); err == nil {
+				t.Fatal("CodecExec() succeeded, want error")
+			}
+			for path, want := range map[string]string{codecPath: codec, supportPath: support} {
+				if got, _ := os.ReadFile(path); string(got) != want {
+					t.Errorf("%s written before the conflict was reported", filepath.Base(path))
+				}
+			}
+		})
+	}
+}
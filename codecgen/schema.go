@@ -0,0 +1,127 @@
+package codecgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TableSchema 从项目中 gf gen dao 生成的代码里解析出的表结构
+type TableSchema struct {
+	Table   string
+	Source  string   // 解析的文件
+	Columns []string // 按定义顺序的列名
+}
+
+// Has 判断表中是否存在该列
+func (s *TableSchema) Has(column string) bool {
+	for _, c := range s.Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSchema 依次从 app/dao/internal 的 Columns 定义和 app/model/internal 的 orm 标签中读取表结构，
+// 都找不到时返回 nil
+func LoadSchema(table string) (*TableSchema, error) {
+	daoFile := filepath.Join("app", "dao", "internal", table+".go")
+	columns, err := parseColumnsLiteral(daoFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) > 0 {
+		return &TableSchema{Table: table, Source: daoFile, Columns: columns}, nil
+	}
+
+	modelFile := filepath.Join("app", "model", "internal", table+".go")
+	columns, err = parseOrmTags(modelFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) > 0 {
+		return &TableSchema{Table: table, Source: modelFile, Columns: columns}, nil
+	}
+	return nil, nil
+}
+
+// parseFileIfExists 文件不存在时返回 nil
+func parseFileIfExists(path string) (*ast.File, error) {
+	b, err := PathExists(path)
+	if err != nil || !b {
+		return nil, err
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	return file, nil
+}
+
+// parseColumnsLiteral 解析形如 userColumns{Id: "id", Uid: "uid"} 的列定义
+func parseColumnsLiteral(path string) ([]string, error) {
+	file, err := parseFileIfExists(path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+	var columns []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok || len(columns) > 0 {
+			return len(columns) == 0
+		}
+		ident, ok := lit.Type.(*ast.Ident)
+		if !ok || !strings.HasSuffix(ident.Name, "Columns") {
+			return true
+		}
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			if v, ok := kv.Value.(*ast.BasicLit); ok && v.Kind == token.STRING {
+				if s, err := strconv.Unquote(v.Value); err == nil {
+					columns = append(columns, s)
+				}
+			}
+		}
+		return false
+	})
+	return columns, nil
+}
+
+// parseOrmTags 解析 model 结构体字段上的 orm:"id,primary" 标签
+func parseOrmTags(path string) ([]string, error) {
+	file, err := parseFileIfExists(path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+	var columns []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		st, ok := n.(*ast.StructType)
+		if !ok || len(columns) > 0 {
+			return len(columns) == 0
+		}
+		for _, field := range st.Fields.List {
+			if field.Tag == nil {
+				continue
+			}
+			tag, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				continue
+			}
+			orm := reflect.StructTag(tag).Get("orm")
+			if name := strings.Split(orm, ",")[0]; name != "" {
+				columns = append(columns, name)
+			}
+		}
+		return false
+	})
+	return columns, nil
+}
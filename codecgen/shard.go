@@ -0,0 +1,49 @@
+package codecgen
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	ShardMod   = "mod"   // key % 分表数
+	ShardCrc32 = "crc32" // crc32(十进制的key) % 分表数
+)
+
+// checkShards 校验分表参数，返回每个分表的后缀，如 00..99。不分表时返回 nil
+func checkShards(cfg Config) ([]string, error) {
+	if cfg.ShardCount == 0 {
+		return nil, nil
+	}
+	if cfg.ShardCount < 2 {
+		return nil, fmt.Errorf("-shards 必须大于1")
+	}
+	switch cfg.ShardFunc {
+	case "", ShardMod, ShardCrc32:
+	default:
+		return nil, fmt.Errorf("未知的 -shard-func %q，可选 mod,crc32", cfg.ShardFunc)
+	}
+	switch {
+	case cfg.Encoding != "" && cfg.Encoding != EncodingProtobuf:
+		return nil, fmt.Errorf("-shards 不支持 -encoding %s", cfg.Encoding)
+	case cfg.Layout != "" && cfg.Layout != LayoutString:
+		return nil, fmt.Errorf("-shards 不支持 -layout %s", cfg.Layout)
+	case cfg.WarmUp:
+		return nil, fmt.Errorf("-shards 不支持 -warmup")
+	case cfg.Test:
+		return nil, fmt.Errorf("-shards 暂不支持 -test")
+	}
+
+	width := cfg.ShardWidth
+	if min := len(strconv.Itoa(cfg.ShardCount - 1)); width < min {
+		if width > 0 {
+			return nil, fmt.Errorf("-shard-width %d 不足以表示 %d 个分表", width, cfg.ShardCount)
+		}
+		width = min
+	}
+	suffixes := make([]string, cfg.ShardCount)
+	for i := range suffixes {
+		suffixes[i] = fmt.Sprintf("%0*d", width, i)
+	}
+	return suffixes, nil
+}
@@ -0,0 +1,241 @@
+package codecgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// DefaultSpecFile codec 声明文件的默认路径
+const DefaultSpecFile = "cache/codecs.json"
+
+// builtinDefaults 和 slpctl codec 参数的默认值一致，声明文件和 defaults 中都没有设置时使用
+var builtinDefaults = Config{
+	UniqueKey:  "id",
+	Module:     "slp",
+	SoftDelete: SoftDeleteAuto,
+}
+
+// Spec codec 的声明文件，每一项对应一次 slpctl codec 的参数
+type Spec struct {
+	Defaults *Config  `json:"defaults,omitempty"` // codec 中没有设置的字段使用这里的值
+	Policy   *Policy  `json:"policy,omitempty"`   // 生成前检查每个codec的过期时间和redis db
+	Codecs   []Config `json:"codecs"`
+}
+
+// LoadSpec 读取声明文件，文件不存在时返回空的 Spec
+func LoadSpec(path string) (*Spec, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Spec{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	// 拼错的字段直接报错，避免被静默忽略
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(spec); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	return spec, nil
+}
+
+// WriteSpec 写入声明文件，目录不存在时自动创建
+func WriteSpec(path string, spec *Spec) error {
+	content, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// Put 添加一个codec，已有相同表和列表列的codec时替换，返回是否替换
+func (s *Spec) Put(cfg Config) bool {
+	for i, c := range s.Codecs {
+		if c.TableName == cfg.TableName && c.List == cfg.List {
+			s.Codecs[i] = cfg
+			return true
+		}
+	}
+	s.Codecs = append(s.Codecs, cfg)
+	return false
+}
+
+// Configs 返回填充了默认值的codec，tables 不为空时只返回这些表
+func (s *Spec) Configs(tables []string) ([]Config, error) {
+	defaults := builtinDefaults
+	if s.Defaults != nil {
+		if s.Defaults.TableName != "" || s.Defaults.List != "" {
+			return nil, fmt.Errorf("defaults 中不能设置 table 和 list")
+		}
+		defaults = *s.Defaults
+		fillDefaults(&defaults, builtinDefaults)
+	}
+	seen := make(map[string]bool)
+	var configs []Config
+	for i, cfg := range s.Codecs {
+		if cfg.TableName == "" {
+			return nil, fmt.Errorf("第 %d 个codec没有设置 table", i+1)
+		}
+		name := codecName(cfg.TableName, cfg.List)
+		if seen[name] {
+			return nil, fmt.Errorf("codec %s 重复声明", name)
+		}
+		seen[name] = true
+		if len(tables) > 0 && !containsString(tables, cfg.TableName) {
+			continue
+		}
+		fill := defaults
+		if cfg.Seconds > 0 || cfg.Hours > 0 || cfg.explicit["seconds"] || cfg.explicit["hours"] {
+			// seconds 和 hours 是同一个设置，codec 中设置了其中一个时不再使用默认的过期时间
+			fill.Seconds, fill.Hours = 0, 0
+		}
+		fillDefaults(&cfg, fill)
+		configs = append(configs, cfg)
+	}
+	for _, table := range tables {
+		if !containsConfig(configs, table) {
+			return nil, fmt.Errorf("声明文件中没有表 %s", table)
+		}
+	}
+	return configs, nil
+}
+
+// Resolve 返回填充了默认值并通过 policy 检查的codec，tables 不为空时只返回这些表
+func (s *Spec) Resolve(tables []string) ([]Config, error) {
+	configs, err := s.Configs(tables)
+	if err != nil || s.Policy == nil {
+		return configs, err
+	}
+	var issues []string
+	for _, cfg := range configs {
+		for _, issue := range s.Policy.CheckConfig(cfg) {
+			issues = append(issues, codecName(cfg.TableName, cfg.List)+": "+issue)
+		}
+	}
+	if len(issues) > 0 {
+		return nil, fmt.Errorf("不符合 policy 的约定:\n  %s", strings.Join(issues, "\n  "))
+	}
+	return configs, nil
+}
+
+// codecName 表名，列表codec为 <table>.<list>
+func codecName(table, list string) string {
+	if list != "" {
+		return table + "." + list
+	}
+	return table
+}
+
+// fillDefaults 把 cfg 中没有设置的字段设置为 defaults 中的值。从声明文件读取的codec中写了的字段都算设置过，
+// 写成零值的字段（如 "not_found": false）保留零值，可以关闭 defaults 中打开的选项；其他字段按是否为零值判断
+func fillDefaults(cfg *Config, defaults Config) {
+	v, d := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(defaults)
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		key, ok := jsonKey(t.Field(i))
+		if v.Field(i).IsZero() && !(ok && cfg.explicit[key]) {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+}
+
+// fieldSet json 字段名的集合
+type fieldSet map[string]bool
+
+// configJSON 没有自定义 json 方法的 Config，避免递归
+type configJSON Config
+
+// UnmarshalJSON 解析codec并记录写了哪些可省略的字段，拼错的字段直接报错
+func (c *Config) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode((*configJSON)(c)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	// 没有 omitempty 的字段总会写入声明文件，写了零值也不代表显式设置
+	c.explicit = make(fieldSet, len(fields))
+	t := reflect.TypeOf(*c)
+	for i := 0; i < t.NumField(); i++ {
+		key, ok := jsonKey(t.Field(i))
+		if _, set := fields[key]; ok && set && strings.HasSuffix(t.Field(i).Tag.Get("json"), ",omitempty") {
+			c.explicit[key] = true
+		}
+	}
+	return nil
+}
+
+// MarshalJSON 按字段顺序输出，声明文件中写成零值的字段会被 omitempty 省略，补回来避免重新写入后被默认值覆盖
+func (c Config) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(configJSON(c))
+	if err != nil || len(c.explicit) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		key, ok := jsonKey(v.Type().Field(i))
+		if !ok {
+			continue
+		}
+		value, ok := fields[key]
+		if !ok && c.explicit[key] {
+			if value, err = json.Marshal(v.Field(i).Interface()); err != nil {
+				return nil, err
+			}
+		} else if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonKey 字段在声明文件中的名字，不写入声明文件的字段返回 false
+func jsonKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return "", false
+	}
+	return name, true
+}
+
+func containsConfig(configs []Config, table string) bool {
+	for _, cfg := range configs {
+		if cfg.TableName == table {
+			return true
+		}
+	}
+	return false
+}
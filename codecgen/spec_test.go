@@ -0,0 +1,180 @@
+package codecgen
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestSpec 把声明文件写入临时目录后用 LoadSpec 读取
+func loadTestSpec(t *testing.T, content string) *Spec {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "codecs.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestSpecConfigs(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		tables  []string
+		want    func(t *testing.T, configs []Config)
+		wantErr string
+	}{
+		{
+			name: "builtin defaults",
+			spec: `{"codecs": [{"table": "user_info", "redis_db": "user", "seconds": 60}]}`,
+			want: func(t *testing.T, configs []Config) {
+				cfg := configs[0]
+				if cfg.UniqueKey != "id" || cfg.Module != "slp" || cfg.SoftDelete != SoftDeleteAuto || cfg.Seconds != 60 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "defaults fill unset fields",
+			spec: `{
+				"defaults": {"module": "room", "seconds": 300, "not_found": true, "jitter": 10},
+				"codecs": [{"table": "user_info", "redis_db": "user", "unique_key": "uid"}]
+			}`,
+			want: func(t *testing.T, configs []Config) {
+				cfg := configs[0]
+				if cfg.Module != "room" || cfg.Seconds != 300 || !cfg.NotFound || cfg.Jitter != 10 || cfg.UniqueKey != "uid" || cfg.SoftDelete != SoftDeleteAuto {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "codec overrides defaults",
+			spec: `{
+				"defaults": {"module": "room", "seconds": 300, "tier": "local"},
+				"codecs": [{"table": "user_info", "redis_db": "user", "module": "slp", "tier": "redis"}]
+			}`,
+			want: func(t *testing.T, configs []Config) {
+				if cfg := configs[0]; cfg.Module != "slp" || cfg.Tier != TierRedis || cfg.Seconds != 300 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "hours replaces default seconds",
+			spec: `{
+				"defaults": {"seconds": 300},
+				"codecs": [{"table": "user_info", "redis_db": "user", "hours": 2}]
+			}`,
+			want: func(t *testing.T, configs []Config) {
+				if cfg := configs[0]; cfg.Seconds != 0 || cfg.Hours != 2 {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "explicit zero keeps zero",
+			spec: `{
+				"defaults": {"seconds": 300, "not_found": true, "jitter": 10},
+				"codecs": [
+					{"table": "user_info", "redis_db": "user", "not_found": false, "jitter": 0},
+					{"table": "room_info", "redis_db": "room"}
+				]
+			}`,
+			want: func(t *testing.T, configs []Config) {
+				if cfg := configs[0]; cfg.NotFound || cfg.Jitter != 0 || cfg.Seconds != 300 {
+					t.Errorf("user_info config = %+v", cfg)
+				}
+				if cfg := configs[1]; !cfg.NotFound || cfg.Jitter != 10 {
+					t.Errorf("room_info config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "filter tables",
+			spec: `{"codecs": [
+				{"table": "user_info", "redis_db": "user"},
+				{"table": "room_info", "redis_db": "room"},
+				{"table": "room_info", "redis_db": "room", "list": "uid"}
+			]}`,
+			tables: []string{"room_info"},
+			want: func(t *testing.T, configs []Config) {
+				if len(configs) != 2 || configs[0].List != "" || configs[1].List != "uid" {
+					t.Errorf("configs = %+v", configs)
+				}
+			},
+		},
+		{
+			name:    "table in defaults",
+			spec:    `{"defaults": {"table": "user_info"}, "codecs": []}`,
+			wantErr: "defaults 中不能设置 table 和 list",
+		},
+		{
+			name:    "missing table",
+			spec:    `{"codecs": [{"redis_db": "user"}]}`,
+			wantErr: "第 1 个codec没有设置 table",
+		},
+		{
+			name:    "duplicate codec",
+			spec:    `{"codecs": [{"table": "user_info", "redis_db": "user"}, {"table": "user_info", "redis_db": "user"}]}`,
+			wantErr: "codec user_info 重复声明",
+		},
+		{
+			name:    "unknown table",
+			spec:    `{"codecs": [{"table": "user_info", "redis_db": "user"}]}`,
+			tables:  []string{"room_info"},
+			wantErr: "声明文件中没有表 room_info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := loadTestSpec(t, tt.spec).Configs(tt.tables)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Configs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want(t, configs)
+		})
+	}
+}
+
+func TestSpecKeepsExplicitZero(t *testing.T) {
+	spec := loadTestSpec(t, `{
+		"defaults": {"not_found": true},
+		"codecs": [{"table": "user_info", "redis_db": "user", "not_found": false}]
+	}`)
+	content, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"not_found":false`) {
+		t.Fatalf("spec = %s", content)
+	}
+	configs, err := loadTestSpec(t, string(content)).Configs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configs[0].NotFound {
+		t.Errorf("config = %+v", configs[0])
+	}
+}
+
+func TestLoadSpecUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codecs.json")
+	if err := os.WriteFile(path, []byte(`{"codecs": [{"table": "user_info", "notfound": true}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSpec(path); err == nil || !strings.Contains(err.Error(), "notfound") {
+		t.Errorf("LoadSpec() error = %v", err)
+	}
+}
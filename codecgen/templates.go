@@ -1,77 +1,1828 @@
 package codecgen
 
-var templateV2 = `package codec  
-  
-import (  
-    "context"   
-	"database/sql"   
-	"fmt"   
-	"time"  
-	"%mode/app/dao"   
-	"%mode/app/pb"   
-	"%mode/library/go2cache" 
-	"%mode/library"   
+import (
+	"text/template"
+)
+
+// generatedHeader 带区域标记的生成文件的文件头
+const generatedHeader = "// Code generated by slpctl codec. slpctl:begin/end 标记区域内的手动修改会在重新生成时被检测并保留\n"
+
+var codecTemplate = template.Must(template.New("codec").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+{{- if .ShardCrc32}}
+	"hash/crc32"
+	"strconv"
+{{- end}}
+	"time"
+
+	"{{.Module}}/app/dao"
+	"{{.Module}}/app/pb"
+	"{{.Module}}/library"
+	"{{.Module}}/library/go2cache"
+
 	"google.golang.org/protobuf/proto"
-)  
-var (  
-    %pbNameRedisCodec *go2cache.Server   
-	expiredTtl%pbNameSeconds     = int64(%ttl)
-)  
-  
-const (  
-    tableKey%pbName = "table.key.%tableKey.%id.%d"
-)  
-  
-func init() {  
-	expiredTime := time.Hour    
-	if expiredTtl%pbNameSeconds > 0 {   
-		expiredTime = time.Duration(expiredTtl%pbNameSeconds) * time.Second  
-	}   
-	%pbNameRedisCodec = go2cache.NewOnlyRedisServer(library.%redis-db, &%lowerNameCodec{}, go2cache.WithTtl(expiredTime))
-	TableCodecMap["%tableKey"] = %pbNameRedisCodec
-}  
-  
-type %lowerNameCodec struct {  
-}  
-  
-func (b %lowerNameCodec) Pt() proto.Message {  
-    return &pb.Entity%pbName{}
-}  
-  
-// Key 生成缓存key  
-func (b %lowerNameCodec) Key(key uint32) string {  
-    if key == 0 {     
-		return ""   
-	}   
-	return fmt.Sprintf(tableKey%pbName, key)
-}  
-  
-// Pk 根据proto数据，获取主键信息  
-func (b %lowerNameCodec) Pk(data proto.Message) uint32 {  
-    if entity, ok := data.(*pb.Entity%pbName); ok {    
-		id:= entity.%entity_id
+)
+
+var (
+	{{.PbName}}RedisCodec *go2cache.Server
+{{- if .Local}}
+	{{.LowerName}}Local *LocalCache
+	localTtl{{.PbName}}Seconds = int64({{.LocalTtl}})
+{{- end}}
+	expiredTtl{{.PbName}}Seconds = int64({{.TtlSeconds}})
+{{- if .NotFound}}
+	notFoundTtl{{.PbName}}Seconds = int64({{.NotFoundTtl}})
+{{- end}}
+{{- if .TtlFunc}}
+	expiredJitter{{.PbName}}Percent = {{.Jitter}}
+{{- end}}
+{{- if .LoadTimeout}}
+	loadTimeout{{.PbName}}Millis = int64({{.LoadTimeout}})
+{{- end}}
+{{- if .SingleFlight}}
+	{{.LowerName}}OneFlight     KeyFlight
+	{{.LowerName}}FindAllFlight KeyFlight
+{{- end}}
+)
+
+const (
+	tableKey{{.PbName}} = {{printf "%q" .KeyFormat}}
+)
+
+// slpctl:begin init
+func init() {
+	{{.PbName}}RedisCodec = new{{.PbName}}RedisCodec()
+	TableCodecMap["{{.Table}}"] = {{.PbName}}RedisCodec
+}
+// slpctl:end init
+
+// slpctl:begin new{{.PbName}}RedisCodec
+// new{{.PbName}}RedisCodec 使用 library.{{.RedisDb}} 当前的连接创建 go2cache 的 Server，测试替换连接后重新创建
+func new{{.PbName}}RedisCodec() *go2cache.Server {
+	expiredTime := time.Hour
+	if expiredTtl{{.PbName}}Seconds > 0 {
+		expiredTime = time.Duration(expiredTtl{{.PbName}}Seconds) * time.Second
+	}
+{{- if .Local}}
+	{{.LowerName}}Local = NewLocalCache({{.LocalSize}}, time.Duration(localTtl{{.PbName}}Seconds)*time.Second)
+	return go2cache.NewServer(library.{{.RedisDb}}, &{{.LowerName}}Codec{}, go2cache.WithTtl(expiredTime), go2cache.WithLocal({{.LowerName}}Local)
+{{- else}}
+	return go2cache.NewOnlyRedisServer(library.{{.RedisDb}}, &{{.LowerName}}Codec{}, go2cache.WithTtl(expiredTime)
+{{- end}}
+{{- if .TtlFunc}}, go2cache.WithTtlFunc(func(data proto.Message) time.Duration {
+{{- if .TtlHook}}
+		ttl := ({{.LowerName}}Codec{}).TtlOf(data)
+		if ttl <= 0 {
+			ttl = expiredTime
+		}
+		return JitterTtl(ttl, expiredJitter{{.PbName}}Percent)
+{{- else}}
+		return JitterTtl(expiredTime, expiredJitter{{.PbName}}Percent)
+{{- end}}
+	})
+{{- end}})
+}
+// slpctl:end new{{.PbName}}RedisCodec
+
+type {{.LowerName}}Codec struct {
+}
+
+// slpctl:begin Pt
+func (b {{.LowerName}}Codec) Pt() proto.Message {
+	return &pb.Entity{{.PbName}}{}
+}
+// slpctl:end Pt
+
+// slpctl:begin Key
+// Key 生成缓存key
+func (b {{.LowerName}}Codec) Key(key uint32) string {
+	if key == 0 {
+		return ""
+	}
+	return fmt.Sprintf(tableKey{{.PbName}}, key)
+}
+// slpctl:end Key
+
+// slpctl:begin Pk
+// Pk 根据proto数据，获取主键信息
+func (b {{.LowerName}}Codec) Pk(data proto.Message) uint32 {
+	if entity, ok := data.(*pb.Entity{{.PbName}}); ok {
+		id := entity.{{.KeyField}}
 		return uint32(id)
-	}    
+	}
+	return 0
+}
+// slpctl:end Pk
+{{- if .Shards}}
+
+// slpctl:begin shard
+// {{.LowerName}}Shard 根据 {{.KeyColumn}} 计算分表序号，0 对应 {{.Table}}_{{index .Shards 0}}
+func {{.LowerName}}Shard(key uint32) int {
+{{- if .ShardCrc32}}
+	return int(crc32.ChecksumIEEE([]byte(strconv.FormatUint(uint64(key), 10))) % {{.ShardCount}})
+{{- else}}
+	return int(key % {{.ShardCount}})
+{{- end}}
+}
+
+// {{.LowerName}}GroupByShard 按分表对 key 分组，每个分表只查询一次
+func {{.LowerName}}GroupByShard(keys []uint32) map[int][]uint32 {
+	groups := make(map[int][]uint32)
+	for _, key := range keys {
+		shard := {{.LowerName}}Shard(key)
+		groups[shard] = append(groups[shard], key)
+	}
+	return groups
+}
+// slpctl:end shard
+{{- end}}
+{{- if .TtlHook}}
+
+// slpctl:begin TtlOf
+// TtlOf 单条记录的过期时间，返回0时使用默认的过期时间，热点数据可以在这里返回更长的时间
+func (b {{.LowerName}}Codec) TtlOf(data proto.Message) time.Duration {
 	return 0
-}  
-  
-func (b %lowerNameCodec) One(ctx context.Context, key uint32, data proto.Message) error {  
-    //排除大字段，description  
-    err := dao.%pbName.Ctx(ctx).Where("%id = ?", key).Struct(data)  
-	if err != nil && err != sql.ErrNoRows {      
-		return err   
-	}    
+}
+// slpctl:end TtlOf
+{{- end}}
+
+// slpctl:begin {{.OneFunc}}
+func (b {{.LowerName}}Codec) {{.OneFunc}}(ctx context.Context, key uint32, data proto.Message) error {
+{{- template "loadTimeout" .}}
+{{- if .NotFound}}
+	if n, err := library.{{.RedisDb}}.Exists(ctx, b.notFoundKey(key)).Result(); err == nil && n > 0 {
+		return &NotFoundError{Table: "{{.Table}}", Key: key}
+	}
+	//排除大字段，description
+{{- if .Shards}}
+	shard := {{.LowerName}}Shard(key)
+	{{template "shardModel" .}}
+{{- end}}
+	err := {{if .Shards}}m{{else}}dao.{{.PbName}}.Ctx(ctx){{end}}.Where("{{.KeyColumn}} = ?", key){{.Query}}.Struct(data)
+	if err == sql.ErrNoRows {
+		ttl := time.Duration(notFoundTtl{{.PbName}}Seconds) * time.Second
+		if err = library.{{.RedisDb}}.Set(ctx, b.notFoundKey(key), 1, ttl).Err(); err != nil {
+			return err
+		}
+		return &NotFoundError{Table: "{{.Table}}", Key: key}
+	}
+	return err
+}
+// slpctl:end {{.OneFunc}}
+
+// slpctl:begin notFoundKey
+// notFoundKey 不存在的记录在redis中的占位key
+func (b {{.LowerName}}Codec) notFoundKey(key uint32) string {
+	return b.Key(key) + {{printf "%q" .NotFoundMark}}
+}
+// slpctl:end notFoundKey
+
+// slpctl:begin skipNotFound
+// skipNotFound 去掉有不存在标记的key，这些key不再查询db
+func (b {{.LowerName}}Codec) skipNotFound(ctx context.Context, keys []uint32) ([]uint32, error) {
+	notFoundKeys := make([]string, len(keys))
+	for i, key := range keys {
+		notFoundKeys[i] = b.notFoundKey(key)
+	}
+	values, err := library.{{.RedisDb}}.MGet(ctx, notFoundKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	remaining := make([]uint32, 0, len(keys))
+	for i, key := range keys {
+		if values[i] == nil {
+			remaining = append(remaining, key)
+		}
+	}
+	return remaining, nil
+}
+// slpctl:end skipNotFound
+
+// slpctl:begin markNotFound
+// markNotFound 给db中不存在的key写入不存在标记，notFoundTtl{{.PbName}}Seconds 内不再查询db
+func (b {{.LowerName}}Codec) markNotFound(ctx context.Context, keys []uint32) error {
+	ttl := time.Duration(notFoundTtl{{.PbName}}Seconds) * time.Second
+	for _, key := range keys {
+		if err := library.{{.RedisDb}}.Set(ctx, b.notFoundKey(key), 1, ttl).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+// slpctl:end markNotFound
+{{- else}}
+	//排除大字段，description
+{{- if .Shards}}
+	shard := {{.LowerName}}Shard(key)
+	{{template "shardModel" .}}
+{{- end}}
+	err := {{if .Shards}}m{{else}}dao.{{.PbName}}.Ctx(ctx){{end}}.Where("{{.KeyColumn}} = ?", key){{.Query}}.Struct(data)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
 	return nil
-}  
-  
-func (b %lowerNameCodec) FindAll(ctx context.Context, keys []uint32, callback go2cache.Find2Item) error {  
-    res, err := dao.%pbName.Ctx(ctx).Where("%id in (?)", keys).FindAll()   
-	if err != nil { 
-		return err   
-	}   
-	for _, item := range res {     
-		callback(item)  
-	}  
+}
+// slpctl:end {{.OneFunc}}
+{{- end}}
+
+// slpctl:begin {{.FindAllFunc}}
+{{- if .SingleFlight}}
+// {{.FindAllFunc}} 返回查到的记录，key 为{{.KeyColumn}}，值是读取这条记录的闭包，等待同一个key的请求都用自己的 callback 读取
+func (b {{.LowerName}}Codec) {{.FindAllFunc}}(ctx context.Context, keys []uint32) (map[uint32]interface{}, error) {
+{{- else}}
+func (b {{.LowerName}}Codec) {{.FindAllFunc}}(ctx context.Context, keys []uint32, callback go2cache.Find2Item) error {
+{{- end}}
+{{- template "loadTimeout" .}}
+{{- if .NotFound}}
+	keys, err := b.skipNotFound(ctx, keys)
+	if err != nil {
+		return {{if .SingleFlight}}nil, {{end}}err
+	}
+	if len(keys) == 0 {
+		return {{if .SingleFlight}}nil, {{end}}nil
+	}
+{{- end}}
+{{- if .SingleFlight}}
+	rows := make(map[uint32]interface{}, len(keys))
+{{- else if .NotFound}}
+	found := make(map[uint32]bool, len(keys))
+{{- end}}
+{{- if .Shards}}
+	for shard, keys := range {{.LowerName}}GroupByShard(keys) {
+		{{template "shardModel" .}}
+{{- end}}
+{{- if .Chunk}}
+	for _, keys := range Chunks(keys, {{.Chunk}}) {
+{{- end}}
+	res, err := {{if .Shards}}m{{else}}dao.{{.PbName}}.Ctx(ctx){{end}}.Where("{{.KeyColumn}} in (?)", keys){{.Query}}.FindAll()
+	if err != nil {
+		return {{if .SingleFlight}}nil, {{end}}err
+	}
+	for _, item := range res {
+{{- if .SingleFlight}}
+		item := item
+		rows[item["{{.KeyColumn}}"].Uint32()] = func(callback go2cache.Find2Item) { callback(item) }
+{{- else}}
+{{- if .NotFound}}
+		found[item["{{.KeyColumn}}"].Uint32()] = true
+{{- end}}
+		callback(item)
+{{- end}}
+	}
+{{- if .Chunk}}
+	}
+{{- end}}
+{{- if .Shards}}
+	}
+{{- end}}
+{{- if .NotFound}}
+	// db中不存在的key写入不存在标记
+	var missing []uint32
+	for _, key := range keys {
+		if {{if .SingleFlight}}rows[key] == nil{{else}}!found[key]{{end}} {
+			missing = append(missing, key)
+		}
+	}
+	if err := b.markNotFound(ctx, missing); err != nil {
+		return {{if .SingleFlight}}nil, {{end}}err
+	}
+{{- end}}
+{{- if .SingleFlight}}
+	return rows, nil
+{{- else}}
+	return nil
+{{- end}}
+}
+// slpctl:end {{.FindAllFunc}}
+{{- if .SingleFlight}}
+
+// slpctl:begin singleflight.One
+// {{if .Metrics}}one{{else}}One{{end}} 合并同一个key并发的回源，等待中的请求共享第一个请求的结果，包括它的ctx取消和超时
+func (b {{.LowerName}}Codec) {{if .Metrics}}one{{else}}One{{end}}(ctx context.Context, key uint32, data proto.Message) error {
+	return {{.LowerName}}OneFlight.Load(ctx, []uint32{key}, func(keys []uint32) (map[uint32]interface{}, error) {
+		entity := b.Pt()
+		if err := b.{{.OneFunc}}(ctx, key, entity); err != nil {
+			return nil, err
+		}
+		return map[uint32]interface{}{key: entity}, nil
+	}, func(value interface{}) {
+		proto.Merge(data, value.(proto.Message))
+	})
+}
+// slpctl:end singleflight.One
+
+// slpctl:begin singleflight.FindAll
+// {{if .Metrics}}findAll{{else}}FindAll{{end}} 按key合并并发的回源，其他请求正在查询的key等待它的结果，其余的key一起查询db
+func (b {{.LowerName}}Codec) {{if .Metrics}}findAll{{else}}FindAll{{end}}(ctx context.Context, keys []uint32, callback go2cache.Find2Item) error {
+	return {{.LowerName}}FindAllFlight.Load(ctx, keys, func(keys []uint32) (map[uint32]interface{}, error) {
+		return b.{{.FindAllFunc}}(ctx, keys)
+	}, func(value interface{}) {
+		value.(func(go2cache.Find2Item))(callback)
+	})
+}
+// slpctl:end singleflight.FindAll
+{{- end}}
+{{- if .Metrics}}
+
+// slpctl:begin metrics.One
+// One 记录回源db的条数和耗时，上报方式见 SetMetrics
+func (b {{.LowerName}}Codec) One(ctx context.Context, key uint32, data proto.Message) error {
+	start := time.Now()
+	countLoads(ctx, 1)
+	err := b.one(ctx, key, data)
+	ObserveLoad("{{.MetricsLabel}}", "One", 1, start, err)
+	return err
+}
+// slpctl:end metrics.One
+
+// slpctl:begin metrics.FindAll
+// FindAll 记录回源db的条数和耗时，上报方式见 SetMetrics
+func (b {{.LowerName}}Codec) FindAll(ctx context.Context, keys []uint32, callback go2cache.Find2Item) error {
+	start := time.Now()
+	countLoads(ctx, len(keys))
+	err := b.findAll(ctx, keys, callback)
+	ObserveLoad("{{.MetricsLabel}}", "FindAll", len(keys), start, err)
+	return err
+}
+// slpctl:end metrics.FindAll
+
+// slpctl:begin metrics.Get
+// Get{{.PbName}} 通过 {{.PbName}}RedisCodec 读取一条记录，没有回源db时记为缓存命中，上报方式见 SetMetrics
+func Get{{.PbName}}(ctx context.Context, key uint32, data proto.Message) error {
+	ctx, loads := WithLoadCounter(ctx)
+	err := {{.PbName}}RedisCodec.Get(ctx, key, data)
+	ObserveRead("{{.MetricsLabel}}", 1, loads(), err)
+	return err
+}
+// slpctl:end metrics.Get
+
+// slpctl:begin metrics.Find
+// Find{{.PbName}} 通过 {{.PbName}}RedisCodec 批量读取，没有回源db的key记为缓存命中，上报方式见 SetMetrics
+func Find{{.PbName}}(ctx context.Context, keys []uint32) ({{.FindResult}}, error) {
+	ctx, loads := WithLoadCounter(ctx)
+	res, err := {{.PbName}}RedisCodec.Find(ctx, keys)
+	ObserveRead("{{.MetricsLabel}}", len(keys), loads(), err)
+	return res, err
+}
+// slpctl:end metrics.Find
+{{- end}}
+{{- if .Local}}
+
+// slpctl:begin InvalidateLocal
+// Invalidate{{.PbName}}Local 删除本进程的一级缓存，其他实例的一级缓存在 localTtl{{.PbName}}Seconds 后过期
+func Invalidate{{.PbName}}Local(keys ...uint32) {
+	codec := {{.LowerName}}Codec{}
+	for _, key := range keys {
+		{{.LowerName}}Local.Del(codec.Key(key))
+	}
+}
+// slpctl:end InvalidateLocal
+{{- end}}
+
+// slpctl:begin Invalidate
+// Invalidate{{.PbName}} 删除缓存，写db后调用，下次读取时重新回源
+func Invalidate{{.PbName}}(ctx context.Context, keys ...uint32) error {
+	codec := {{.LowerName}}Codec{}
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key == 0 {
+			continue
+		}
+		cacheKeys = append(cacheKeys, codec.Key(key){{if .NotFound}}, codec.notFoundKey(key){{end}})
+	}
+	if len(cacheKeys) == 0 {
+		return nil
+	}
+{{- if .Local}}
+	Invalidate{{.PbName}}Local(keys...)
+{{- end}}
+	return library.{{.RedisDb}}.Del(ctx, cacheKeys...).Err()
+}
+// slpctl:end Invalidate
+
+// slpctl:begin Update
+// Update{{.PbName}} 按{{.KeyColumn}}更新db，成功后删除对应的缓存
+func Update{{.PbName}}(ctx context.Context, data interface{}, keys ...uint32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+{{- if .Shards}}
+	for shard, keys := range {{.LowerName}}GroupByShard(keys) {
+		{{template "shardModel" .}}
+		if _, err := m.Data(data).Where("{{.KeyColumn}} in (?)", keys).Update(); err != nil {
+			return err
+		}
+	}
+{{- else}}
+	if _, err := dao.{{.PbName}}.Ctx(ctx).Data(data).Where("{{.KeyColumn}} in (?)", keys).Update(); err != nil {
+		return err
+	}
+{{- end}}
+	return Invalidate{{.PbName}}(ctx, keys...)
+}
+// slpctl:end Update
+
+// slpctl:begin Delete
+// Delete{{.PbName}} 按{{.KeyColumn}}删除db记录，成功后删除对应的缓存
+func Delete{{.PbName}}(ctx context.Context, keys ...uint32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+{{- if .Shards}}
+	for shard, keys := range {{.LowerName}}GroupByShard(keys) {
+		{{template "shardModel" .}}
+		if _, err := m.Where("{{.KeyColumn}} in (?)", keys).Delete(); err != nil {
+			return err
+		}
+	}
+{{- else}}
+	if _, err := dao.{{.PbName}}.Ctx(ctx).Where("{{.KeyColumn}} in (?)", keys).Delete(); err != nil {
+		return err
+	}
+{{- end}}
+	return Invalidate{{.PbName}}(ctx, keys...)
+}
+// slpctl:end Delete
+{{- define "loadTimeout"}}
+{{- if .LoadTimeout}}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(loadTimeout{{.PbName}}Millis)*time.Millisecond)
+	defer cancel()
+{{- end}}
+{{- end}}
+{{- define "shardModel"}}m := dao.{{.PbName}}{{index .Shards 0}}.Ctx(ctx)
+	switch shard {
+{{- range $i, $suffix := .Shards}}{{if $i}}
+	case {{$i}}:
+		m = dao.{{$.PbName}}{{$suffix}}.Ctx(ctx)
+{{- end}}{{end}}
+	}
+{{- end}}
+`))
+
+// supportTemplate codec 包公用的代码，每次生成时覆盖
+var supportTemplate = template.Must(template.New("support").Parse(`// Code generated by slpctl codec. DO NOT EDIT.
+package {{.Package}}
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound db中不存在该记录，可以用 errors.Is(err, ErrNotFound) 判断
+var ErrNotFound = errors.New("codec: record not found")
+
+// NotFoundError 开启了不存在记录缓存的codec，在查不到记录时返回
+type NotFoundError struct {
+	Table string
+	Key   uint32
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("codec: %s %d not found", e.Table, e.Key)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// JitterTtl 在过期时间上随机增加 0~percent% 的时间，避免同一批写入的缓存同时过期
+func JitterTtl(ttl time.Duration, percent int) time.Duration {
+	if percent <= 0 || ttl <= 0 {
+		return ttl
+	}
+	spread := int64(ttl) * int64(percent) / 100
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(spread+1))
+}
+
+// LocalCache 进程内的LRU缓存，作为redis前面的一级缓存
+type LocalCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// NewLocalCache 最多缓存 size 条数据，每条数据 ttl 后过期
+func NewLocalCache(size int, ttl time.Duration) *LocalCache {
+	return &LocalCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *LocalCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expireAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *LocalCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expireAt := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*localEntry)
+		entry.value, entry.expireAt = value, expireAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&localEntry{key: key, value: value, expireAt: expireAt})
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LocalCache) Del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *LocalCache) remove(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*localEntry).key)
+}
+
+// Metrics codec 的监控指标，默认不上报。接入 Prometheus 时实现该接口并在启动时调用 SetMetrics，
+// 如计数器 codec_cache_hits_total{table}、codec_cache_misses_total{table} 和直方图 codec_db_seconds{table,method}
+type Metrics interface {
+	// Hits 缓存命中的条数。protobuf 的codec在 go2cache 内部命中，通过 Get<PbName>/Find<PbName> 读取时才能统计，
+	// 直接调用 <PbName>RedisCodec 时只统计回源
+	Hits(table string, n int)
+	// Misses 回源db的条数
+	Misses(table string, n int)
+	// DbLatency 回源db的耗时，method 为 One 或 FindAll
+	DbLatency(table, method string, elapsed time.Duration, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Hits(table string, n int)                                        {}
+func (noopMetrics) Misses(table string, n int)                                      {}
+func (noopMetrics) DbLatency(table, method string, elapsed time.Duration, err error) {}
+
+var metrics Metrics = noopMetrics{}
+
+// SetMetrics 设置监控指标的上报方式，需要在读取缓存前调用，传入 nil 恢复为不上报
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+// ObserveHits 记录缓存命中的条数
+func ObserveHits(table string, n int) {
+	if n > 0 {
+		metrics.Hits(table, n)
+	}
+}
+
+// loadCounterKey ctx 中回源计数的key
+type loadCounterKey struct{}
+
+// WithLoadCounter 返回记录回源条数的ctx，loads 返回经过这个ctx回源db的条数。
+// go2cache 把调用方的ctx传给codec的 One/FindAll，开启 -metrics 的codec在回源时累加计数
+func WithLoadCounter(ctx context.Context) (context.Context, func() int) {
+	n := new(int64)
+	return context.WithValue(ctx, loadCounterKey{}, n), func() int { return int(atomic.LoadInt64(n)) }
+}
+
+// countLoads 累加 ctx 中的回源计数，ctx 不是 WithLoadCounter 返回的时忽略
+func countLoads(ctx context.Context, n int) {
+	if counter, ok := ctx.Value(loadCounterKey{}).(*int64); ok {
+		atomic.AddInt64(counter, int64(n))
+	}
+}
+
+// ObserveRead 记录一次通过 go2cache 的读取，n 为读取的条数，loads 为其中回源db的条数，其余的记为命中。读取出错时不记录
+func ObserveRead(table string, n, loads int, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return
+	}
+	ObserveHits(table, n-loads)
+}
+
+// ObserveLoad 记录一次回源db，n 为回源的条数，记录不存在不算作错误
+func ObserveLoad(table, method string, n int, start time.Time, err error) {
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	metrics.Misses(table, n)
+	metrics.DbLatency(table, method, time.Since(start), err)
+}
+
+// Chunks 把 keys 按 size 分批，每批一次db查询
+func Chunks(keys []uint32, size int) [][]uint32 {
+	if size <= 0 || len(keys) <= size {
+		return [][]uint32{keys}
+	}
+	chunks := make([][]uint32, 0, (len(keys)+size-1)/size)
+	for size < len(keys) {
+		keys, chunks = keys[size:], append(chunks, keys[:size:size])
+	}
+	return append(chunks, keys)
+}
+
+// errFlightPanic 查询db时 panic，等待同一个key的请求返回的错误
+var errFlightPanic = errors.New("codec: load panicked")
+
+// KeyFlight 按key合并并发的回源，同一个key同时只有一个请求查询db，其他请求等待它的结果，
+// 包括它的错误和ctx取消、超时。零值可以直接使用
+type KeyFlight struct {
+	mu    sync.Mutex
+	calls map[uint32]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Load 没有请求在查询的key一起调用一次 load，其他key等待正在进行的查询。
+// load 返回查到的值，fn 对每个查到的值调用一次，db中不存在的key不会调用
+func (f *KeyFlight) Load(ctx context.Context, keys []uint32, load func(keys []uint32) (map[uint32]interface{}, error), fn func(value interface{})) error {
+	own, wait := f.claim(keys)
+	if len(own) > 0 {
+		values, err := f.load(own, load)
+		if err != nil {
+			return err
+		}
+		for _, key := range own {
+			if value, ok := values[key]; ok {
+				fn(value)
+			}
+		}
+	}
+	for _, call := range wait {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if call.err != nil {
+			return call.err
+		}
+		if call.value != nil {
+			fn(call.value)
+		}
+	}
 	return nil
-}  
+}
+
+// claim 返回由当前请求查询的key，和需要等待的其他请求的查询，重复的key只算一次
+func (f *KeyFlight) claim(keys []uint32) ([]uint32, []*flightCall) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[uint32]*flightCall)
+	}
+	var own []uint32
+	var wait []*flightCall
+	seen := make(map[uint32]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if call, ok := f.calls[key]; ok {
+			wait = append(wait, call)
+			continue
+		}
+		f.calls[key] = &flightCall{done: make(chan struct{})}
+		own = append(own, key)
+	}
+	return own, wait
+}
+
+// load 查询 keys 后唤醒等待的请求，load panic 时也会唤醒
+func (f *KeyFlight) load(keys []uint32, load func(keys []uint32) (map[uint32]interface{}, error)) (values map[uint32]interface{}, err error) {
+	err = errFlightPanic
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, key := range keys {
+			call := f.calls[key]
+			call.value, call.err = values[key], err
+			delete(f.calls, key)
+			close(call.done)
+		}
+	}()
+	return load(keys)
+}
+
+// WarmUpFunc 预热一张表，batch 为每页条数，qps 为每秒最多写入的条数，返回写入的条数
+type WarmUpFunc func(ctx context.Context, batch, qps int) (int, error)
+
+// WarmUpMap 按表名注册的预热函数
+var WarmUpMap = make(map[string]WarmUpFunc)
+
+// WarmUpLimiter 预热时限制每秒写入的条数，避免压垮刚恢复的redis和db
+type WarmUpLimiter struct {
+	qps   int
+	start time.Time
+	done  int
+}
+
+// NewWarmUpLimiter qps 小于等于0时不限速
+func NewWarmUpLimiter(qps int) *WarmUpLimiter {
+	return &WarmUpLimiter{qps: qps, start: time.Now()}
+}
+
+// Wait 记录写入了 n 条，超过速率时等待
+func (l *WarmUpLimiter) Wait(ctx context.Context, n int) error {
+	if l.qps <= 0 {
+		return nil
+	}
+	l.done += n
+	wait := time.Duration(l.done)*time.Second/time.Duration(l.qps) - time.Since(l.start)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// EntityCodec -encoding json/msgpack 生成的不依赖 proto 的实体缓存
+type EntityCodec interface {
+	Key(key uint32) string
+	// Invalidate 删除缓存，写db后调用
+	Invalidate(ctx context.Context, keys ...uint32) error
+}
+
+// EntityCodecMap 按表名注册的 EntityCodec
+var EntityCodecMap = make(map[string]EntityCodec)
+
+// PickFields 从整行数据中取出部分列，fields 为空时返回整行
+func PickFields(row map[string]string, fields []string) map[string]string {
+	if len(fields) == 0 {
+		return row
+	}
+	res := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if value, ok := row[field]; ok {
+			res[field] = value
+		}
+	}
+	return res
+}
+
+// ListCodec 按非唯一列缓存的唯一键列表，如 room_id 对应的所有 uid
+type ListCodec interface {
+	Key(key uint32) string
+	// Ids 返回 key 对应的唯一键列表，先读redis，未命中时查询db并回写
+	Ids(ctx context.Context, key uint32) ([]uint32, error)
+	// IdsMulti 批量读取，未命中的 key 合并成一次db查询，key 为 0 时不在结果中
+	IdsMulti(ctx context.Context, keys []uint32) (map[uint32][]uint32, error)
+	// Invalidate 删除缓存，新增、删除记录或修改该列后调用
+	Invalidate(ctx context.Context, keys ...uint32) error
+}
+
+// ListCodecMap 按 <表名>.<列名> 注册的列表codec
+var ListCodecMap = make(map[string]ListCodec)
+
+// EncodeIds 列表在redis中保存为逗号分隔的字符串，空列表也会被缓存
+func EncodeIds(ids []uint32) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// DecodeIds 解析 EncodeIds 的结果
+func DecodeIds(s string) ([]uint32, error) {
+	if s == "" {
+		return []uint32{}, nil
+	}
+	parts := strings.Split(s, ",")
+	ids := make([]uint32, len(parts))
+	for i, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("codec: invalid id list %q: %v", s, err)
+		}
+		ids[i] = uint32(id)
+	}
+	return ids, nil
+}
+`))
+
+// structTemplate -encoding json/msgpack 生成的codec，缓存 model.<PbName>，直接读写redis
+var structTemplate = template.Must(template.New("struct").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+	"database/sql"
+{{- if eq .Encoding "json"}}
+	"encoding/json"
+{{- end}}
+	"fmt"
+	"time"
+
+	"{{.Module}}/app/dao"
+	"{{.Module}}/app/model"
+	"{{.Module}}/library"
+{{- if eq .Encoding "msgpack"}}
+
+	"github.com/vmihailenco/msgpack/v5"
+{{- end}}
+)
+
+var (
+	{{.PbName}}EntityCodec EntityCodec
+	expiredTtl{{.PbName}}Seconds = int64({{.TtlSeconds}})
+{{- if .Jitter}}
+	expiredJitter{{.PbName}}Percent = {{.Jitter}}
+{{- end}}
+)
+
+const (
+	tableKey{{.PbName}} = {{printf "%q" .KeyFormat}}
+)
+
+// slpctl:begin init
+func init() {
+	{{.PbName}}EntityCodec = {{.LowerName}}Codec{}
+	EntityCodecMap["{{.Table}}"] = {{.PbName}}EntityCodec
+}
+// slpctl:end init
+
+// {{.LowerName}}Codec 以 {{.Encoding}} 格式缓存 model.{{.PbName}}
+type {{.LowerName}}Codec struct {
+}
+
+// slpctl:begin Key
+// Key 生成缓存key
+func (b {{.LowerName}}Codec) Key(key uint32) string {
+	if key == 0 {
+		return ""
+	}
+	return fmt.Sprintf(tableKey{{.PbName}}, key)
+}
+// slpctl:end Key
+
+// slpctl:begin Pk
+// Pk 根据model数据，获取主键信息
+func (b {{.LowerName}}Codec) Pk(data *model.{{.PbName}}) uint32 {
+	if data == nil {
+		return 0
+	}
+	return uint32(data.{{.KeyField}})
+}
+// slpctl:end Pk
+
+// slpctl:begin encode
+func (b {{.LowerName}}Codec) encode(data *model.{{.PbName}}) ([]byte, error) {
+	return {{.Encoding}}.Marshal(data)
+}
+
+func (b {{.LowerName}}Codec) decode(raw []byte) (*model.{{.PbName}}, error) {
+	data := &model.{{.PbName}}{}
+	if err := {{.Encoding}}.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+// slpctl:end encode
+
+// slpctl:begin ttl
+func (b {{.LowerName}}Codec) ttl() time.Duration {
+	expiredTime := time.Hour
+	if expiredTtl{{.PbName}}Seconds > 0 {
+		expiredTime = time.Duration(expiredTtl{{.PbName}}Seconds) * time.Second
+	}
+{{- if .Jitter}}
+	return JitterTtl(expiredTime, expiredJitter{{.PbName}}Percent)
+{{- else}}
+	return expiredTime
+{{- end}}
+}
+// slpctl:end ttl
+
+// slpctl:begin Get
+// Get{{.PbName}} 读取一条记录，不存在时返回 nil
+func Get{{.PbName}}(ctx context.Context, key uint32) (*model.{{.PbName}}, error) {
+	res, err := Get{{.PbName}}Multi(ctx, []uint32{key})
+	if err != nil {
+		return nil, err
+	}
+	return res[key], nil
+}
+// slpctl:end Get
+
+// slpctl:begin GetMulti
+// Get{{.PbName}}Multi 批量读取，先读redis，未命中的合并成一次db查询并回写，不存在的记录不在结果中
+func Get{{.PbName}}Multi(ctx context.Context, keys []uint32) (map[uint32]*model.{{.PbName}}, error) {
+	codec := {{.LowerName}}Codec{}
+	res := make(map[uint32]*model.{{.PbName}}, len(keys))
+	cacheKeys := make([]string, 0, len(keys))
+	valid := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		if key == 0 {
+			continue
+		}
+		cacheKeys = append(cacheKeys, codec.Key(key))
+		valid = append(valid, key)
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+	values, err := library.{{.RedisDb}}.MGet(ctx, cacheKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var missed []uint32
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			missed = append(missed, valid[i])
+			continue
+		}
+		data, err := codec.decode([]byte(s))
+		if err != nil {
+			missed = append(missed, valid[i])
+			continue
+		}
+		res[valid[i]] = data
+	}
+{{- if .Metrics}}
+	ObserveHits("{{.MetricsLabel}}", len(res))
+{{- end}}
+	if len(missed) == 0 {
+		return res, nil
+	}
+
+{{- if .Metrics}}
+	start := time.Now()
+{{- end}}
+	list, err := codec.FindAll(ctx, missed)
+{{- if .Metrics}}
+	ObserveLoad("{{.MetricsLabel}}", "FindAll", len(missed), start, err)
+{{- end}}
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range list {
+		raw, err := codec.encode(data)
+		if err != nil {
+			return nil, err
+		}
+		key := codec.Pk(data)
+		if err = library.{{.RedisDb}}.Set(ctx, codec.Key(key), raw, codec.ttl()).Err(); err != nil {
+			return nil, err
+		}
+		res[key] = data
+	}
+	return res, nil
+}
+// slpctl:end GetMulti
+
+// slpctl:begin FindAll
+func (b {{.LowerName}}Codec) FindAll(ctx context.Context, keys []uint32) ([]*model.{{.PbName}}, error) {
+	var list []*model.{{.PbName}}
+	err := dao.{{.PbName}}.Ctx(ctx).Where("{{.KeyColumn}} in (?)", keys){{.Query}}.Structs(&list)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return list, nil
+}
+// slpctl:end FindAll
+
+` + entityWriteHelpers))
+
+// hashTemplate -layout hash 生成的codec，每条记录保存为一个hash，每列一个field
+var hashTemplate = template.Must(template.New("hash").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"{{.Module}}/app/dao"
+	"{{.Module}}/library"
+)
+
+var (
+	{{.PbName}}EntityCodec EntityCodec
+	expiredTtl{{.PbName}}Seconds = int64({{.TtlSeconds}})
+{{- if .Jitter}}
+	expiredJitter{{.PbName}}Percent = {{.Jitter}}
+{{- end}}
+)
+
+const (
+	tableKey{{.PbName}} = {{printf "%q" .KeyFormat}}
+)
+
+// slpctl:begin init
+func init() {
+	{{.PbName}}EntityCodec = {{.LowerName}}Codec{}
+	EntityCodecMap["{{.Table}}"] = {{.PbName}}EntityCodec
+}
+// slpctl:end init
+
+// {{.LowerName}}Codec 以hash结构缓存 {{.Table}} 的记录，field 是列名，值是列的字符串形式
+type {{.LowerName}}Codec struct {
+}
+
+// slpctl:begin Key
+// Key 生成缓存key
+func (b {{.LowerName}}Codec) Key(key uint32) string {
+	if key == 0 {
+		return ""
+	}
+	return fmt.Sprintf(tableKey{{.PbName}}, key)
+}
+// slpctl:end Key
+
+// slpctl:begin ttl
+func (b {{.LowerName}}Codec) ttl() time.Duration {
+	expiredTime := time.Hour
+	if expiredTtl{{.PbName}}Seconds > 0 {
+		expiredTime = time.Duration(expiredTtl{{.PbName}}Seconds) * time.Second
+	}
+{{- if .Jitter}}
+	return JitterTtl(expiredTime, expiredJitter{{.PbName}}Percent)
+{{- else}}
+	return expiredTime
+{{- end}}
+}
+// slpctl:end ttl
+
+// slpctl:begin Get
+// Get{{.PbName}}Fields 读取一条记录的部分列，fields 为空时读取全部列，记录不存在时返回 nil
+func Get{{.PbName}}Fields(ctx context.Context, key uint32, fields ...string) (map[string]string, error) {
+	res, err := Get{{.PbName}}FieldsMulti(ctx, []uint32{key}, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return res[key], nil
+}
+// slpctl:end Get
+
+// slpctl:begin GetMulti
+// Get{{.PbName}}FieldsMulti 批量读取部分列，redis中缺少任意一列的记录会从db重新加载整行，不存在的记录不在结果中
+func Get{{.PbName}}FieldsMulti(ctx context.Context, keys []uint32, fields ...string) (map[uint32]map[string]string, error) {
+	codec := {{.LowerName}}Codec{}
+	valid := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		if key != 0 {
+			valid = append(valid, key)
+		}
+	}
+	if len(valid) == 0 {
+		return map[uint32]map[string]string{}, nil
+	}
+	res, missed, err := codec.read(ctx, valid, fields)
+	if err != nil {
+		return nil, err
+	}
+{{- if .Metrics}}
+	ObserveHits("{{.MetricsLabel}}", len(res))
+{{- end}}
+	if len(missed) == 0 {
+		return res, nil
+	}
+
+{{- if .Metrics}}
+	start := time.Now()
+{{- end}}
+	rows, err := codec.FindAll(ctx, missed)
+{{- if .Metrics}}
+	ObserveLoad("{{.MetricsLabel}}", "FindAll", len(missed), start, err)
+{{- end}}
+	if err != nil {
+		return nil, err
+	}
+	if err = codec.write(ctx, rows); err != nil {
+		return nil, err
+	}
+	for key, row := range rows {
+		res[key] = PickFields(row, fields)
+	}
+	return res, nil
+}
+// slpctl:end GetMulti
+
+// slpctl:begin read
+// read 用一个pipeline读取所有key，fields 为空时用 HGETALL 读取整行，否则用 HMGET 读取部分列，缺少任意一列都视为未命中
+func (b {{.LowerName}}Codec) read(ctx context.Context, keys []uint32, fields []string) (map[uint32]map[string]string, []uint32, error) {
+	pipe := library.{{.RedisDb}}.Pipeline()
+	rows := make([]func() (map[string]string, error), len(keys))
+	cols := make([]func() ([]interface{}, error), len(keys))
+	for i, key := range keys {
+		if len(fields) == 0 {
+			rows[i] = pipe.HGetAll(ctx, b.Key(key)).Result
+		} else {
+			cols[i] = pipe.HMGet(ctx, b.Key(key), fields...).Result
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	// Exec 已经返回了第一个失败的命令的错误，这里只取结果
+	res := make(map[uint32]map[string]string, len(keys))
+	var missed []uint32
+	for i, key := range keys {
+		var values map[string]string
+		if len(fields) == 0 {
+			values, _ = rows[i]()
+		} else if list, _ := cols[i](); len(list) == len(fields) {
+			values = make(map[string]string, len(fields))
+			for j, value := range list {
+				s, ok := value.(string)
+				if !ok {
+					values = nil
+					break
+				}
+				values[fields[j]] = s
+			}
+		}
+		if len(values) == 0 {
+			missed = append(missed, key)
+			continue
+		}
+		res[key] = values
+	}
+	return res, missed, nil
+}
+// slpctl:end read
+
+// slpctl:begin write
+// write 用一个pipeline写入整行并设置过期时间，失败时删除这些key，避免留下不过期的数据
+func (b {{.LowerName}}Codec) write(ctx context.Context, rows map[uint32]map[string]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	pipe := library.{{.RedisDb}}.Pipeline()
+	cacheKeys := make([]string, 0, len(rows))
+	for key, row := range rows {
+		values := make(map[string]interface{}, len(row))
+		for field, value := range row {
+			values[field] = value
+		}
+		cacheKey := b.Key(key)
+		cacheKeys = append(cacheKeys, cacheKey)
+		pipe.HSet(ctx, cacheKey, values)
+		pipe.Expire(ctx, cacheKey, b.ttl())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		library.{{.RedisDb}}.Del(ctx, cacheKeys...)
+		return err
+	}
+	return nil
+}
+// slpctl:end write
+
+// slpctl:begin FindAll
+// FindAll 一次查询所有 key 的整行数据，按 {{.KeyColumn}} 返回
+func (b {{.LowerName}}Codec) FindAll(ctx context.Context, keys []uint32) (map[uint32]map[string]string, error) {
+	res, err := dao.{{.PbName}}.Ctx(ctx).Where("{{.KeyColumn}} in (?)", keys){{.Query}}.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[uint32]map[string]string, len(res))
+	for _, item := range res {
+		row := make(map[string]string, len(item))
+		for column, value := range item {
+			row[column] = value.String()
+		}
+		rows[item["{{.KeyColumn}}"].Uint32()] = row
+	}
+	return rows, nil
+}
+// slpctl:end FindAll
+` + entityWriteHelpers))
+
+// warmUpTemplate -warmup 生成的预热函数
+var warmUpTemplate = template.Must(template.New("warmup").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+	"fmt"
+
+	"{{.Module}}/app/dao"
+)
+
+// slpctl:begin init
+func init() {
+	WarmUpMap["{{.Table}}"] = WarmUp{{.PbName}}
+}
+// slpctl:end init
+
+
+// slpctl:begin WarmUp
+// WarmUp{{.PbName}} 按 {{.KeyColumn}} 顺序分页读取 {{.Table}} 的唯一键
+{{- if .Hash}}，通过 Get{{.PbName}}FieldsMulti
+{{- else if .Encoding}}，通过 Get{{.PbName}}Multi
+{{- else}}，通过 {{.PbName}}RedisCodec
+{{- end}} 加载未缓存的记录并写入缓存
+func WarmUp{{.PbName}}(ctx context.Context, batch, qps int) (int, error) {
+	limiter := NewWarmUpLimiter(qps)
+	var last uint32
+	total := 0
+	for {
+		values, err := dao.{{.PbName}}.Ctx(ctx).Fields("{{.KeyColumn}}").Where("{{.KeyColumn}} > ?", last){{.Wheres}}.Order("{{.KeyColumn}}").Limit(batch).Array()
+		if err != nil {
+			return total, err
+		}
+		if len(values) == 0 {
+			return total, nil
+		}
+		keys := make([]uint32, len(values))
+		for i, value := range values {
+			keys[i] = value.Uint32()
+		}
+		if keys[len(keys)-1] <= last {
+			return total, fmt.Errorf("warm up {{.Table}}: {{.KeyColumn}} %d 不是递增的，无法分页", keys[len(keys)-1])
+		}
+		last = keys[len(keys)-1]
+{{- if .Hash}}
+		if _, err = Get{{.PbName}}FieldsMulti(ctx, keys); err != nil {
+{{- else if .Encoding}}
+		if _, err = Get{{.PbName}}Multi(ctx, keys); err != nil {
+{{- else}}
+		if _, err = {{.PbName}}RedisCodec.Find(ctx, keys); err != nil {
+{{- end}}
+			return total, err
+		}
+		total += len(keys)
+		if err = limiter.Wait(ctx, len(keys)); err != nil {
+			return total, err
+		}
+	}
+}
+// slpctl:end WarmUp
+`))
+
+// warmUpCmdTemplate 预热的 cmd 入口，只在不存在时生成
+var warmUpCmdTemplate = template.Must(template.New("warmup_cmd").Parse(`// Code generated by slpctl codec. 只在文件不存在时生成，可以直接修改
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	{{.Package}} "{{.CodecPath}}"
+)
+
+func main() {
+	tables := flag.String("t", "", "预热的表，逗号分隔，默认全部")
+	batch := flag.Int("batch", 500, "每页读取的条数")
+	qps := flag.Int("qps", 2000, "每张表每秒最多写入的条数，0 不限速")
+	timeout := flag.Duration("timeout", time.Hour, "预热的总超时时间")
+	flag.Parse()
+
+	// TODO: 按服务启动的方式初始化配置、db 和 redis 连接
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var names []string
+	if *tables != "" {
+		names = strings.Split(*tables, ",")
+	} else {
+		for name := range {{.Package}}.WarmUpMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		warmUp, ok := {{.Package}}.WarmUpMap[name]
+		if !ok {
+			log.Fatalf("表 %s 没有生成预热函数，请使用 slpctl codec -warmup 重新生成", name)
+		}
+		start := time.Now()
+		n, err := warmUp(ctx, *batch, *qps)
+		if err != nil {
+			log.Fatalf("预热 %s 失败，已写入 %d 条: %v", name, n, err)
+		}
+		log.Printf("预热 %s 完成，写入 %d 条，耗时 %s", name, n, time.Since(start))
+	}
+}
+`))
+
+// entityWriteHelpers 不经过 go2cache 的codec共用的删除缓存和写db的函数
+const entityWriteHelpers = `
+// slpctl:begin Invalidate
+// Invalidate 删除缓存，写db后调用，下次读取时重新回源
+func (b {{.LowerName}}Codec) Invalidate(ctx context.Context, keys ...uint32) error {
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key == 0 {
+			continue
+		}
+		cacheKeys = append(cacheKeys, b.Key(key))
+	}
+	if len(cacheKeys) == 0 {
+		return nil
+	}
+	return library.{{.RedisDb}}.Del(ctx, cacheKeys...).Err()
+}
+
+// Invalidate{{.PbName}} 删除缓存，写db后调用，下次读取时重新回源
+func Invalidate{{.PbName}}(ctx context.Context, keys ...uint32) error {
+	return {{.LowerName}}Codec{}.Invalidate(ctx, keys...)
+}
+// slpctl:end Invalidate
+
+// slpctl:begin Update
+// Update{{.PbName}} 按{{.KeyColumn}}更新db，成功后删除对应的缓存
+func Update{{.PbName}}(ctx context.Context, data interface{}, keys ...uint32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := dao.{{.PbName}}.Ctx(ctx).Data(data).Where("{{.KeyColumn}} in (?)", keys).Update(); err != nil {
+		return err
+	}
+	return Invalidate{{.PbName}}(ctx, keys...)
+}
+// slpctl:end Update
+
+// slpctl:begin Delete
+// Delete{{.PbName}} 按{{.KeyColumn}}删除db记录，成功后删除对应的缓存
+func Delete{{.PbName}}(ctx context.Context, keys ...uint32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := dao.{{.PbName}}.Ctx(ctx).Where("{{.KeyColumn}} in (?)", keys).Delete(); err != nil {
+		return err
+	}
+	return Invalidate{{.PbName}}(ctx, keys...)
+}
+// slpctl:end Delete
 `
+
+// listTemplate -list 生成的列表codec，缓存非唯一列到唯一键列表的映射
+var listTemplate = template.Must(template.New("list").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"{{.Module}}/app/dao"
+	"{{.Module}}/library"
+)
+
+var (
+	{{.ListName}}ListCodec ListCodec
+	expiredTtl{{.ListName}}Seconds = int64({{.TtlSeconds}})
+{{- if .Jitter}}
+	expiredJitter{{.ListName}}Percent = {{.Jitter}}
+{{- end}}
+)
+
+const (
+	tableKey{{.ListName}} = {{printf "%q" .KeyFormat}}
+)
+
+// slpctl:begin init
+func init() {
+	{{.ListName}}ListCodec = {{.LowerName}}Codec{}
+	ListCodecMap["{{.Table}}.{{.ListColumn}}"] = {{.ListName}}ListCodec
+}
+// slpctl:end init
+
+// {{.LowerName}}Codec {{.ListColumn}} 到 {{.KeyColumn}} 列表的缓存，数据通过 {{.PbName}}RedisCodec 按 {{.KeyColumn}} 读取
+type {{.LowerName}}Codec struct {
+}
+
+// slpctl:begin Key
+// Key 生成缓存key
+func (b {{.LowerName}}Codec) Key(key uint32) string {
+	if key == 0 {
+		return ""
+	}
+	return fmt.Sprintf(tableKey{{.ListName}}, key)
+}
+// slpctl:end Key
+
+// slpctl:begin ttl
+func (b {{.LowerName}}Codec) ttl() time.Duration {
+	expiredTime := time.Hour
+	if expiredTtl{{.ListName}}Seconds > 0 {
+		expiredTime = time.Duration(expiredTtl{{.ListName}}Seconds) * time.Second
+	}
+{{- if .Jitter}}
+	return JitterTtl(expiredTime, expiredJitter{{.ListName}}Percent)
+{{- else}}
+	return expiredTime
+{{- end}}
+}
+// slpctl:end ttl
+
+// slpctl:begin Ids
+func (b {{.LowerName}}Codec) Ids(ctx context.Context, key uint32) ([]uint32, error) {
+	res, err := b.IdsMulti(ctx, []uint32{key})
+	if err != nil {
+		return nil, err
+	}
+	return res[key], nil
+}
+// slpctl:end Ids
+
+// slpctl:begin IdsMulti
+func (b {{.LowerName}}Codec) IdsMulti(ctx context.Context, keys []uint32) (map[uint32][]uint32, error) {
+	res := make(map[uint32][]uint32, len(keys))
+	cacheKeys := make([]string, 0, len(keys))
+	valid := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		if key == 0 {
+			continue
+		}
+		cacheKeys = append(cacheKeys, b.Key(key))
+		valid = append(valid, key)
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+	values, err := library.{{.RedisDb}}.MGet(ctx, cacheKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var missed []uint32
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			missed = append(missed, valid[i])
+			continue
+		}
+		ids, err := DecodeIds(s)
+		if err != nil {
+			missed = append(missed, valid[i])
+			continue
+		}
+		res[valid[i]] = ids
+	}
+{{- if .Metrics}}
+	ObserveHits("{{.MetricsLabel}}", len(res))
+{{- end}}
+	if len(missed) == 0 {
+		return res, nil
+	}
+
+{{- if .Metrics}}
+	start := time.Now()
+{{- end}}
+	loaded, err := b.FindAll(ctx, missed)
+{{- if .Metrics}}
+	ObserveLoad("{{.MetricsLabel}}", "FindAll", len(missed), start, err)
+{{- end}}
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missed {
+		ids := loaded[key]
+		if ids == nil {
+			ids = []uint32{}
+		}
+		res[key] = ids
+		if err = library.{{.RedisDb}}.Set(ctx, b.Key(key), EncodeIds(ids), b.ttl()).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+// slpctl:end IdsMulti
+
+// slpctl:begin FindAll
+// FindAll 一次查询所有 key 的记录，按 {{.ListColumn}} 分组成 {{.KeyColumn}} 列表
+func (b {{.LowerName}}Codec) FindAll(ctx context.Context, keys []uint32) (map[uint32][]uint32, error) {
+	res, err := dao.{{.PbName}}.Ctx(ctx).Fields("{{.ListColumn}},{{.KeyColumn}}").Where("{{.ListColumn}} in (?)", keys){{.Query}}.Order("{{.KeyColumn}}").FindAll()
+	if err != nil {
+		return nil, err
+	}
+	grouped := make(map[uint32][]uint32, len(keys))
+	for _, item := range res {
+		key := item["{{.ListColumn}}"].Uint32()
+		grouped[key] = append(grouped[key], item["{{.KeyColumn}}"].Uint32())
+	}
+	return grouped, nil
+}
+// slpctl:end FindAll
+
+// slpctl:begin Invalidate
+func (b {{.LowerName}}Codec) Invalidate(ctx context.Context, keys ...uint32) error {
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key == 0 {
+			continue
+		}
+		cacheKeys = append(cacheKeys, b.Key(key))
+	}
+	if len(cacheKeys) == 0 {
+		return nil
+	}
+	return library.{{.RedisDb}}.Del(ctx, cacheKeys...).Err()
+}
+// slpctl:end Invalidate
+
+// slpctl:begin Invalidate{{.ListName}}
+// Invalidate{{.ListName}} 新增、删除 {{.Table}} 的记录或修改 {{.ListColumn}} 后调用，修改时新旧 {{.ListColumn}} 都要传入
+func Invalidate{{.ListName}}(ctx context.Context, keys ...uint32) error {
+	return {{.LowerName}}Codec{}.Invalidate(ctx, keys...)
+}
+// slpctl:end Invalidate{{.ListName}}
+`))
+
+// testTemplate 每个codec的单元测试
+var testTemplate = template.Must(template.New("test").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"context"
+{{- if .NotFound}}
+	"errors"
+{{- end}}
+	"testing"
+
+	"{{.Module}}/app/dao"
+	"{{.Module}}/app/pb"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// slpctl:begin TestKey
+func Test{{.PbName}}CodecKey(t *testing.T) {
+	codec := {{.LowerName}}Codec{}
+	if got := codec.Key(0); got != "" {
+		t.Errorf("Key(0) = %q, want empty", got)
+	}
+	if got, want := codec.Key(10086), {{printf "%q" (printf .KeyFormat 10086)}}; got != want {
+		t.Errorf("Key(10086) = %q, want %q", got, want)
+	}
+}
+// slpctl:end TestKey
+
+// slpctl:begin TestPk
+func Test{{.PbName}}CodecPk(t *testing.T) {
+	codec := {{.LowerName}}Codec{}
+	entity := &pb.Entity{{.PbName}}{ {{- .KeyField}}: 10086}
+	if got := codec.Pk(entity); got != 10086 {
+		t.Errorf("Pk() = %d, want 10086", got)
+	}
+	if got, want := codec.Key(codec.Pk(entity)), codec.Key(10086); got != want {
+		t.Errorf("Key(Pk()) = %q, want %q", got, want)
+	}
+	if got := codec.Pk(codec.Pt()); got != 0 {
+		t.Errorf("Pk(empty entity) = %d, want 0", got)
+	}
+	if got := codec.Pk(nil); got != 0 {
+		t.Errorf("Pk(nil) = %d, want 0", got)
+	}
+}
+// slpctl:end TestPk
+
+// slpctl:begin use{{.PbName}}TestDB
+// use{{.PbName}}TestDB 让 dao.{{.PbName}} 使用 env 的 mock db 查询，测试结束后恢复
+func use{{.PbName}}TestDB(t *testing.T, env *codecTestEnv) {
+	old := dao.{{.PbName}}.M
+	dao.{{.PbName}}.M = env.DB.Model("{{.Table}}").Safe()
+	t.Cleanup(func() { dao.{{.PbName}}.M = old })
+	env.ExpectTableFields({{range $i, $c := .TestColumns}}{{if $i}}, {{end}}{{printf "%q" $c}}{{end}})
+}
+// slpctl:end use{{.PbName}}TestDB
+
+// slpctl:begin TestGet
+// Test{{.PbName}}CodecGet 通过 {{.PbName}}RedisCodec 读取，第一次回源db并写入redis，第二次命中缓存
+func Test{{.PbName}}CodecGet(t *testing.T) {
+	env := newCodecTestEnv(t, true)
+	use{{.PbName}}TestDB(t, env)
+	server := new{{.PbName}}RedisCodec()
+	env.Mock.ExpectQuery("SELECT .+ FROM .*{{.Table}}").WillReturnRows(sqlmock.NewRows([]string{"{{.KeyColumn}}"}).AddRow(10086))
+
+	ctx := context.Background()
+	data := &pb.Entity{{.PbName}}{}
+	if err := server.Get(ctx, 10086, data); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := ({{.LowerName}}Codec{}).Pk(data); got != 10086 {
+		t.Errorf("Get() loaded key %d, want 10086", got)
+	}
+	if key := {{printf "%q" (printf .KeyFormat 10086)}}; !env.Redis.Exists(key) {
+		t.Errorf("Get() did not cache %s", key)
+	}
+	// 命中缓存时不再查询db，多出的查询会被 sqlmock 拒绝
+	if err := server.Get(ctx, 10086, &pb.Entity{{.PbName}}{}); err != nil {
+		t.Fatalf("Get() from cache error = %v", err)
+	}
+}
+// slpctl:end TestGet
+{{- if .NotFound}}
+
+// slpctl:begin TestNotFound
+// Test{{.PbName}}CodecNotFound 有不存在标记的key直接返回 ErrNotFound，不查询db
+func Test{{.PbName}}CodecNotFound(t *testing.T) {
+	env := newCodecTestEnv(t, false)
+	server := new{{.PbName}}RedisCodec()
+	env.Redis.Set({{printf "%q" (print (printf .KeyFormat 10086) .NotFoundMark)}}, "1")
+
+	err := server.Get(context.Background(), 10086, &pb.Entity{{.PbName}}{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+}
+// slpctl:end TestNotFound
+{{- end}}
+
+// slpctl:begin TestFind
+// Test{{.PbName}}CodecFind 通过 {{.PbName}}RedisCodec 批量读取，查到的记录写入redis
+{{- if .NotFound}}，查不到的key写入不存在标记{{end}}
+func Test{{.PbName}}CodecFind(t *testing.T) {
+	env := newCodecTestEnv(t, true)
+	use{{.PbName}}TestDB(t, env)
+	server := new{{.PbName}}RedisCodec()
+	env.Mock.ExpectQuery("SELECT .+ FROM .*{{.Table}}").WillReturnRows(sqlmock.NewRows([]string{"{{.KeyColumn}}"}).AddRow(1).AddRow(2))
+
+	res, err := server.Find(context.Background(), []uint32{1, 2, 3})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(res) != 2 {
+		t.Errorf("Find() returned %d records, want 2", len(res))
+	}
+	for _, key := range []string{ {{- printf "%q" (printf .KeyFormat 1)}}, {{printf "%q" (printf .KeyFormat 2)}}} {
+		if !env.Redis.Exists(key) {
+			t.Errorf("Find() did not cache %s", key)
+		}
+	}
+{{- if .NotFound}}
+	if key := {{printf "%q" (print (printf .KeyFormat 3) .NotFoundMark)}}; !env.Redis.Exists(key) {
+		t.Errorf("Find() did not mark %s", key)
+	}
+{{- end}}
+}
+// slpctl:end TestFind
+`))
+
+// testEnvTemplate codec 测试公用的环境，所有codec共用，useTestRedis/useTestDB 需要按项目实际情况实现
+var testEnvTemplate = template.Must(template.New("testenv").Parse(generatedHeader + `package {{.Package}}
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"testing"
+{{- if .TestRedis}}
+
+	"{{.Module}}/library"
+{{- end}}
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+{{- if .TestRedis}}
+	"{{.TestRedis.Import}}"
+{{- end}}
+	"github.com/gogf/gf/database/gdb"
+)
+
+// slpctl:begin useTestRedis
+// useTestRedis 把 library 中的 redis 连接指向 addr，返回恢复原连接的函数，返回 nil 时跳过需要 redis 的测试
+func useTestRedis(t *testing.T, addr string) func() {
+{{- if .TestRedis}}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+{{- range .TestRedis.Vars}}
+	old{{.}} := library.{{.}}
+	library.{{.}} = client
+{{- end}}
+	return func() {
+{{- range .TestRedis.Vars}}
+		library.{{.}} = old{{.}}
+{{- end}}
+		client.Close()
+	}
+{{- else}}
+	// library 中的 redis 连接不是 go-redis 的 *redis.Client，需要手动实现
+	return nil
+{{- end}}
+}
+// slpctl:end useTestRedis
+
+// slpctl:begin useTestDB
+// testDBDriver 使用 sqlmock 连接的 mysql 驱动，生成的sql和结果的解析与 mysql 相同
+type testDBDriver struct {
+	*gdb.DriverMysql
+	db *sql.DB
+}
+
+// New gdb 通过返回的驱动调用 Open
+func (d *testDBDriver) New(core *gdb.Core, node *gdb.ConfigNode) (gdb.DB, error) {
+	return &testDBDriver{DriverMysql: &gdb.DriverMysql{Core: core}, db: d.db}, nil
+}
+
+// Open 返回 sqlmock 的连接
+func (d *testDBDriver) Open(node *gdb.ConfigNode) (*sql.DB, error) {
+	return d.db, nil
+}
+
+// testDBCount 每次使用单独的配置分组，gdb 按分组缓存连接和表结构，不能在测试之间共用
+var testDBCount int32
+
+// useTestDB 返回使用 db 查询的 gdb.DB，各个codec的测试用它替换 dao 的连接
+func useTestDB(t *testing.T, db *sql.DB) gdb.DB {
+	group := fmt.Sprintf("slpctl_test_%d", atomic.AddInt32(&testDBCount, 1))
+	if err := gdb.Register(group, &testDBDriver{db: db}); err != nil {
+		t.Fatalf("gdb.Register() error = %v", err)
+	}
+	gdb.SetConfigGroup(group, gdb.ConfigGroup{{"{{"}}Type: group{{"}}"}})
+	testDB, err := gdb.New(group)
+	if err != nil {
+		t.Fatalf("gdb.New() error = %v", err)
+	}
+	return testDB
+}
+// slpctl:end useTestDB
+
+// slpctl:begin codecTestEnv
+// codecTestEnv codec 测试使用的 miniredis 和 mock db
+type codecTestEnv struct {
+	Redis *miniredis.Miniredis
+	Mock  sqlmock.Sqlmock
+	DB    gdb.DB // 使用 Mock 查询的连接
+}
+
+// newCodecTestEnv 启动 miniredis 并替换 library 中的 redis 连接，useDB 为 true 时同时创建 mock db。
+// 替换连接后需要重新创建 go2cache 的 Server，如 new<PbName>RedisCodec()
+func newCodecTestEnv(t *testing.T, useDB bool) *codecTestEnv {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	restore := useTestRedis(t, mr.Addr())
+	if restore == nil {
+		t.Skip("useTestRedis 未实现，跳过需要 redis 的测试")
+	}
+	t.Cleanup(restore)
+	env := &codecTestEnv{Redis: mr}
+	if !useDB {
+		return env
+	}
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// gdb 是否在查询前读取表结构取决于 gf 的版本，查询按sql匹配，不要求顺序
+	mock.MatchExpectationsInOrder(false)
+	env.Mock, env.DB = mock, useTestDB(t, db)
+	return env
+}
+
+// ExpectTableFields gdb 查询表前读取表结构时，按 mysql 的格式返回 columns
+func (env *codecTestEnv) ExpectTableFields(columns ...string) {
+	rows := sqlmock.NewRows([]string{"Field", "Type", "Collation", "Null", "Key", "Default", "Extra", "Privileges", "Comment"})
+	for _, column := range columns {
+		rows.AddRow(column, "varchar(255)", nil, "NO", "", nil, "", "", "")
+	}
+	env.Mock.ExpectQuery("SHOW FULL COLUMNS FROM").WillReturnRows(rows)
+}
+// slpctl:end codecTestEnv
+`))
@@ -0,0 +1,176 @@
+package codecgen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config 还原生成该codec时的参数，查询条件按文件中的原样保留，不再自动识别软删除列
+func (info *CodecInfo) Config() (Config, error) {
+	if info.Module == "" {
+		return Config{}, fmt.Errorf("%s 中没有找到 app/dao 的import，无法确定项目包名", info.File)
+	}
+	if info.KeyColumn == "" {
+		return Config{}, fmt.Errorf("%s 中没有找到唯一键的查询条件", info.File)
+	}
+	if info.RedisDb == "" {
+		return Config{}, fmt.Errorf("%s 中没有找到使用的 library.Redis*", info.File)
+	}
+	kind, column := "key", info.KeyColumn
+	if info.List != "" {
+		kind, column = "list", info.List
+	}
+	ns, version, err := parseKeyFormat(info.KeyFormat, "table."+kind+"."+info.Table+".", column)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %v", info.File, err)
+	}
+	dir := filepath.Dir(info.File)
+	test, warmUp := false, false
+	if info.List == "" {
+		if test, err = generatedTest(filepath.Join(dir, info.Table+"_codec_test.go")); err != nil {
+			return Config{}, err
+		}
+		if warmUp, err = PathExists(filepath.Join(dir, info.Table+warmUpSuffix)); err != nil {
+			return Config{}, err
+		}
+	}
+	cfg := Config{
+		TableName:    info.Table,
+		Seconds:      info.TtlSeconds,
+		RedisDb:      info.RedisDb,
+		UniqueKey:    info.KeyColumn,
+		Module:       info.Module,
+		OutputDir:    dir,
+		Package:      info.Package,
+		Overwrite:    OverwriteMerge,
+		Fields:       info.Fields,
+		Exclude:      info.Exclude,
+		Where:        info.Where,
+		SoftDelete:   SoftDeleteOff,
+		NotFound:     info.NotFoundTtl > 0,
+		NotFoundTtl:  info.NotFoundTtl,
+		Jitter:       info.Jitter,
+		TtlHook:      info.TtlHook,
+		Tier:         TierRedis,
+		KeyVersion:   version,
+		Namespace:    ns,
+		Test:         test,
+		List:         info.List,
+		Encoding:     info.Encoding,
+		Layout:       info.Layout,
+		Metrics:      info.Metrics,
+		WarmUp:       warmUp,
+		ShardCount:   info.ShardCount,
+		ShardFunc:    info.ShardFunc,
+		ShardWidth:   info.ShardWidth,
+		Chunk:        info.Chunk,
+		LoadTimeout:  info.LoadTimeout,
+		SingleFlight: info.SingleFlight,
+	}
+	if info.LocalSize > 0 || info.LocalTtl > 0 {
+		cfg.Tier, cfg.LocalSize, cfg.LocalTtl = TierLocal, info.LocalSize, info.LocalTtl
+	}
+	// 手写的测试文件或 -test 不支持的codec不生成测试
+	cfg.Test = cfg.Test && cfg.testSupported()
+	return cfg, nil
+}
+
+// generatedTest 测试文件存在并且是 slpctl 生成的
+func generatedTest(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(string(content), generatedHeader), nil
+}
+
+// parseKeyFormat 从 [ns.]<mid>[v<version>.]<column>.%d 中读取前缀和版本，是 KeyFormat 的逆过程
+func parseKeyFormat(format, mid, column string) (string, int, error) {
+	suffix := strings.ToLower(column) + ".%d"
+	idx := strings.Index(format, mid)
+	if idx < 0 || !strings.HasSuffix(format, suffix) || idx+len(mid) > len(format)-len(suffix) {
+		return "", 0, fmt.Errorf("缓存key %q 不是 slpctl 生成的格式", format)
+	}
+	ns := strings.TrimSuffix(format[:idx], ".")
+	version := 0
+	if v := format[idx+len(mid) : len(format)-len(suffix)]; v != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(v, "v"), "."))
+		if err != nil || !strings.HasPrefix(v, "v") || !strings.HasSuffix(v, ".") {
+			return "", 0, fmt.Errorf("缓存key %q 中的版本 %q 无法识别", format, v)
+		}
+		version = n
+	}
+	return ns, version, nil
+}
+
+// UpgradeResult 一个codec文件的升级结果
+type UpgradeResult struct {
+	File    string
+	Changed bool
+	Skipped string // 不是 slpctl 生成的codec，跳过的原因
+	Err     error
+}
+
+// UpgradeCodecs 读取目录下已生成的codec的参数，用当前模板按 merge 策略重新生成，tables 为空时升级全部
+func UpgradeCodecs(dir string, tables []string, skipCheck bool) ([]UpgradeResult, error) {
+	infos, skipped, err := ListCodecs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []UpgradeResult
+	if len(tables) == 0 {
+		for _, f := range skipped {
+			results = append(results, UpgradeResult{File: f.File, Skipped: f.Reason})
+		}
+	}
+	for _, info := range infos {
+		if len(tables) > 0 && !containsString(tables, info.Table) {
+			continue
+		}
+		result := UpgradeResult{File: info.File}
+		result.Changed, result.Err = upgradeCodec(info, skipCheck)
+		results = append(results, result)
+	}
+	for _, table := range tables {
+		if !containsTable(infos, table) {
+			return results, fmt.Errorf("%s 中没有表 %s 的codec", dir, table)
+		}
+	}
+	return results, nil
+}
+
+func upgradeCodec(info *CodecInfo, skipCheck bool) (bool, error) {
+	cfg, err := info.Config()
+	if err != nil {
+		return false, err
+	}
+	cfg.SkipCheck = skipCheck
+	before, err := os.ReadFile(info.File)
+	if err != nil {
+		return false, err
+	}
+	if err = CodecExec(cfg); err != nil {
+		return false, err
+	}
+	after, err := os.ReadFile(info.File)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(before, after), nil
+}
+
+func containsTable(infos []*CodecInfo, table string) bool {
+	for _, info := range infos {
+		if info.Table == table {
+			return true
+		}
+	}
+	return false
+}
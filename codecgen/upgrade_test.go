@@ -0,0 +1,74 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpgradeCodecsMixedDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testCodecConfig(dir)
+	cfg.Test = true
+	if err := CodecExec(cfg); err != nil {
+		t.Fatal(err)
+	}
+	hash := testCodecConfig(dir)
+	hash.TableName, hash.Layout = "room", LayoutHash
+	if err := CodecExec(hash); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"gift_codec.go": handWrittenCodec,
+		// hash 的codec不支持 -test，手写的测试文件不能被当作 -test 的结果
+		"room_codec_test.go": "package codec\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := UpgradeCodecs(dir, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]UpgradeResult)
+	for _, r := range results {
+		got[filepath.Base(r.File)] = r
+	}
+	tests := []struct {
+		file    string
+		skipped bool
+	}{
+		{"gift_codec.go", true},
+		{"room_codec.go", false},
+		{"user_info_codec.go", false},
+	}
+	if len(results) != len(tests) {
+		t.Errorf("UpgradeCodecs() = %d results, want %d: %+v", len(results), len(tests), results)
+	}
+	for _, tt := range tests {
+		r, ok := got[tt.file]
+		switch {
+		case !ok:
+			t.Errorf("UpgradeCodecs() missing %s", tt.file)
+		case r.Err != nil:
+			t.Errorf("%s: %v", tt.file, r.Err)
+		case (r.Skipped != "") != tt.skipped:
+			t.Errorf("%s: skipped = %q, want %v", tt.file, r.Skipped, tt.skipped)
+		case r.Changed:
+			t.Errorf("%s: changed by upgrading with the same template", tt.file)
+		}
+	}
+
+	for _, name := range []string{"room_codec_test.go", "user_info_codec_test.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "room_codec_test.go"))
+	if err != nil || string(content) != "package codec\n" {
+		t.Errorf("hand-written room_codec_test.go changed: %q, %v", content, err)
+	}
+}
@@ -0,0 +1,197 @@
+package codecgen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	PbDir    = filepath.Join("app", "pb")    // 项目中 proto 生成代码的目录
+	DaoDir   = filepath.Join("app", "dao")   // 项目中 gf gen dao 生成代码的目录
+	ModelDir = filepath.Join("app", "model") // 项目中 gf gen dao 生成的 model 目录
+)
+
+// integerKinds Pk 可以转换成 uint32 的字段类型，值表示是否可能被截断
+var integerKinds = map[string]bool{
+	"uint32": false, "int32": false, "uint8": false, "int8": false, "uint16": false, "int16": false,
+	"uint64": true, "int64": true, "uint": true, "int": true,
+}
+
+// EntityKey 校验后 pb entity 中的唯一键字段
+type EntityKey struct {
+	Field string // go 字段名
+	Type  string // go 类型
+}
+
+// VerifyProject 生成前确认 pb.Entity<PbName>、dao.<PbName> 和唯一键字段存在，避免生成无法编译的代码。
+// 找不到 app/pb 或 app/dao 目录时只给出提示，返回按 protoc-gen-go 规则推导的字段名
+func VerifyProject(pbName, uniqueKey string) (EntityKey, error) {
+	key, err := verifyEntity(pbName, uniqueKey)
+	if err != nil {
+		return key, err
+	}
+	return key, verifyDao(pbName)
+}
+
+// verifyEntity 确认 pb.Entity<PbName> 和唯一键字段存在
+func verifyEntity(pbName, uniqueKey string) (EntityKey, error) {
+	key := EntityKey{Field: FirstUppers(strings.ToLower(uniqueKey))}
+
+	entity := "Entity" + pbName
+	pbTypes, err := parseTopLevel(PbDir, token.TYPE)
+	if err != nil {
+		return key, err
+	}
+	if pbTypes == nil {
+		fmt.Printf("警告: 没有找到 %s 目录，跳过 pb.%s 的校验\n", PbDir, entity)
+	} else {
+		spec, ok := pbTypes[entity].(*ast.TypeSpec)
+		if !ok {
+			return key, fmt.Errorf("%s 中没有定义 pb.%s，请先在proto中定义并生成代码", PbDir, entity)
+		}
+		if key.Type, err = verifyKeyField("pb."+entity, spec, key.Field, uniqueKey); err != nil {
+			return key, err
+		}
+	}
+
+	return key, nil
+}
+
+// verifyDao 确认 dao.<PbName> 存在，分表时传入每个分表的名字，找不到 app/dao 目录时只给出提示
+func verifyDao(names ...string) error {
+	daoVars, err := parseTopLevel(DaoDir, token.VAR)
+	if err != nil {
+		return err
+	}
+	if daoVars == nil {
+		fmt.Printf("警告: 没有找到 %s 目录，跳过 dao.%s 的校验\n", DaoDir, strings.Join(names, ",dao."))
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := daoVars[name]; !ok {
+			return fmt.Errorf("%s 中没有定义 dao.%s，请先用 gf gen dao 生成表 %s 的dao", DaoDir, name, toSnake(name))
+		}
+	}
+	return nil
+}
+
+// verifyModel -encoding json/msgpack 时确认 model.<PbName>、唯一键字段和 dao.<PbName> 存在，找不到目录时只给出提示
+func verifyModel(pbName, uniqueKey string) error {
+	modelTypes, err := parseTopLevel(ModelDir, token.TYPE)
+	if err != nil {
+		return err
+	}
+	if modelTypes == nil {
+		fmt.Printf("警告: 没有找到 %s 目录，跳过 model.%s 的校验\n", ModelDir, pbName)
+	} else {
+		spec, ok := modelTypes[pbName].(*ast.TypeSpec)
+		if !ok {
+			return fmt.Errorf("%s 中没有定义 model.%s，请先用 gf gen dao 生成表 %s 的model", ModelDir, pbName, toSnake(pbName))
+		}
+		field := FirstUppers(strings.ToLower(uniqueKey))
+		if _, err = verifyKeyField("model."+pbName, spec, field, uniqueKey); err != nil {
+			return err
+		}
+	}
+	return verifyDao(pbName)
+}
+
+// verifyKeyField 确认结构体 name 中有唯一键字段，并且是可以转换成 uint32 的整数类型，返回字段的类型
+func verifyKeyField(name string, spec *ast.TypeSpec, field, uniqueKey string) (string, error) {
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return "", fmt.Errorf("%s 不是结构体", name)
+	}
+	typ := fieldType(st, field)
+	if typ == "" {
+		return "", fmt.Errorf("%s 中没有唯一键 -uq %s 对应的字段 %s", name, uniqueKey, field)
+	}
+	truncated, ok := integerKinds[typ]
+	if !ok {
+		return typ, fmt.Errorf("%s.%s 的类型是 %s，codec 的key只支持整数类型", name, field, typ)
+	}
+	if truncated {
+		fmt.Printf("警告: %s.%s 的类型是 %s，作为缓存key会被转换成 uint32\n", name, field, typ)
+	}
+	return typ, nil
+}
+
+// parseTopLevel 解析目录下（不含子目录）所有文件的顶层声明，目录不存在时返回 nil
+func parseTopLevel(dir string, tok token.Token) (map[string]ast.Spec, error) {
+	b, err := PathExists(dir)
+	if err != nil || !b {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	specs := make(map[string]ast.Spec)
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != tok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					specs[s.Name.Name] = s
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						specs[name.Name] = s
+					}
+				}
+			}
+		}
+	}
+	return specs, nil
+}
+
+// fieldType 返回结构体字段的类型，字段不存在时返回空
+func fieldType(st *ast.StructType, name string) string {
+	for _, field := range st.Fields.List {
+		for _, ident := range field.Names {
+			if ident.Name == name {
+				return exprString(field.Type)
+			}
+		}
+	}
+	return ""
+}
+
+func exprString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + exprString(t.X)
+	case *ast.SelectorExpr:
+		return exprString(t.X) + "." + t.Sel.Name
+	case *ast.ArrayType:
+		return "[]" + exprString(t.Elt)
+	case *ast.MapType:
+		return "map[" + exprString(t.Key) + "]" + exprString(t.Value)
+	case *ast.Ellipsis:
+		return "..." + exprString(t.Elt)
+	case *ast.InterfaceType:
+		if len(t.Methods.List) == 0 {
+			return "interface{}"
+		}
+	case *ast.FuncType:
+		return "func" + signature(fieldTypes(t.Params), fieldTypes(t.Results))
+	}
+	return fmt.Sprintf("%T", expr)
+}
@@ -0,0 +1,97 @@
+package codecgen
+
+import (
+	"strings"
+	"testing"
+)
+
+const pbSrc = `package pb
+
+type EntityUserInfo struct {
+	Uid  uint32
+	Id   uint64
+	Name string
+}
+
+type EntityRoom []string
+`
+
+const daoSrc = `package dao
+
+var UserInfo = userInfoDao{}
+`
+
+func TestVerifyProject(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		pbName    string
+		uniqueKey string
+		want      EntityKey
+		wantErr   string
+	}{
+		{
+			name:   "ok",
+			files:  map[string]string{"app/pb/entity.go": pbSrc, "app/dao/dao.go": daoSrc},
+			pbName: "UserInfo", uniqueKey: "uid",
+			want: EntityKey{Field: "Uid", Type: "uint32"},
+		},
+		{
+			// 会被截断的类型只给出提示
+			name:   "truncated key",
+			files:  map[string]string{"app/pb/entity.go": pbSrc, "app/dao/dao.go": daoSrc},
+			pbName: "UserInfo", uniqueKey: "ID",
+			want: EntityKey{Field: "Id", Type: "uint64"},
+		},
+		{
+			name:   "without project",
+			pbName: "UserInfo", uniqueKey: "user_id",
+			want: EntityKey{Field: "UserId"},
+		},
+		{
+			name:   "missing entity",
+			files:  map[string]string{"app/pb/entity.go": pbSrc},
+			pbName: "Gift", uniqueKey: "id",
+			wantErr: "没有定义 pb.EntityGift",
+		},
+		{
+			name:   "missing key",
+			files:  map[string]string{"app/pb/entity.go": pbSrc},
+			pbName: "UserInfo", uniqueKey: "room_id",
+			wantErr: "pb.EntityUserInfo 中没有唯一键 -uq room_id 对应的字段 RoomId",
+		},
+		{
+			name:   "string key",
+			files:  map[string]string{"app/pb/entity.go": pbSrc},
+			pbName: "UserInfo", uniqueKey: "name",
+			wantErr: "pb.EntityUserInfo.Name 的类型是 string，codec 的key只支持整数类型",
+		},
+		{
+			name:   "not struct",
+			files:  map[string]string{"app/pb/entity.go": pbSrc},
+			pbName: "Room", uniqueKey: "id",
+			wantErr: "pb.EntityRoom 不是结构体",
+		},
+		{
+			name:   "missing dao",
+			files:  map[string]string{"app/pb/entity.go": pbSrc, "app/dao/dao.go": "package dao\n"},
+			pbName: "UserInfo", uniqueKey: "uid",
+			wantErr: "没有定义 dao.UserInfo，请先用 gf gen dao 生成表 user_info 的dao",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useProject(t, tt.files)
+			got, err := VerifyProject(tt.pbName, tt.uniqueKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("VerifyProject() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("VerifyProject() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package codecgen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// warmUpSuffix 预热文件名的后缀，不匹配 *_codec.go，不会被 list 和 upgrade 当作codec
+const warmUpSuffix = "_codec_warmup.go"
+
+// WarmUpCmdDir 预热 cmd 入口的目录。codec 目录在 internal 下时只能被 internal 的上级目录引用，
+// 所以放在 internal 的同级 cmd 目录中，如 rpc/server/internal/cache/codec 对应 rpc/server/cmd/codec_warmup
+func WarmUpCmdDir(outputDir string) string {
+	dir := filepath.ToSlash(filepath.Clean(outputDir))
+	parts := strings.Split(dir, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == "internal" {
+			return filepath.Join(filepath.Join(parts[:i]...), "cmd", "codec_warmup")
+		}
+	}
+	return filepath.Join("cmd", "codec_warmup")
+}
+
+// warmUpCmdFile 预热的 cmd 入口，已存在时跳过，入口中的初始化需要手动补充
+func warmUpCmdFile(outputDir string, data *codecData) (outputFile, error) {
+	rel := filepath.ToSlash(filepath.Clean(outputDir))
+	if filepath.IsAbs(outputDir) || strings.HasPrefix(rel, "../") {
+		return outputFile{}, fmt.Errorf("-warmup 需要在项目根目录执行，并且 -o 是相对路径，才能确定 codec 包的import路径")
+	}
+	data.CodecPath = data.Module + "/" + rel
+	return outputFile{filepath.Join(WarmUpCmdDir(outputDir), "main.go"), warmUpCmdTemplate, OverwriteSkip}, nil
+}
+
+// keepWarmUp 之前用 -warmup 生成的预热文件调用了codec的函数，存在时跟着重新生成，
+// 避免只重新生成codec后预热文件无法编译。不能重新生成时返回错误
+func keepWarmUp(cfg *Config) error {
+	if cfg.WarmUp || cfg.List != "" {
+		return nil
+	}
+	dir := cfg.OutputDir
+	if dir == "" {
+		dir = DefaultOutputDir
+	}
+	path := filepath.Join(dir, cfg.TableName+warmUpSuffix)
+	b, err := PathExists(path)
+	if err != nil || !b {
+		return err
+	}
+	if cfg.ShardCount > 0 {
+		return fmt.Errorf("%s 调用了codec的函数，-shards 不支持 -warmup，请先删除该文件", path)
+	}
+	fmt.Printf("已存在预热文件 %s，同时重新生成\n", path)
+	cfg.WarmUp = true
+	return nil
+}
@@ -0,0 +1,101 @@
+package codecgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarmUpCmdDir(t *testing.T) {
+	tests := []struct {
+		outputDir string
+		want      string
+	}{
+		{DefaultOutputDir, "rpc/server/cmd/codec_warmup"},
+		{"app/internal/codec", "app/cmd/codec_warmup"},
+		{"internal/codec", "cmd/codec_warmup"},
+		{"./app/cache/codec/", "cmd/codec_warmup"},
+		// 多层 internal 时放在最内层的同级
+		{"a/internal/b/internal/codec", "a/internal/b/cmd/codec_warmup"},
+	}
+	for _, tt := range tests {
+		if got := filepath.ToSlash(WarmUpCmdDir(tt.outputDir)); got != tt.want {
+			t.Errorf("WarmUpCmdDir(%q) = %q, want %q", tt.outputDir, got, tt.want)
+		}
+	}
+}
+
+func TestWarmUpCodec(t *testing.T) {
+	runGeneratedCases(t, []generatedCase{
+		{
+			name: "protobuf",
+			edit: func(cfg *Config) { cfg.WarmUp = true },
+			file: "user_info" + warmUpSuffix,
+			contains: []string{
+				`WarmUpMap["user_info"] = WarmUpUserInfo`,
+				`dao.UserInfo.Ctx(ctx).Fields("uid").Where("uid > ?", last).Order("uid").Limit(batch).Array()`,
+				"UserInfoRedisCodec.Find(ctx, keys)",
+			},
+		},
+		{
+			name:     "json",
+			edit:     func(cfg *Config) { cfg.WarmUp, cfg.Encoding = true, EncodingJSON },
+			file:     "user_info" + warmUpSuffix,
+			contains: []string{"GetUserInfoMulti(ctx, keys)"},
+		},
+		{
+			name:     "hash",
+			edit:     func(cfg *Config) { cfg.WarmUp, cfg.Layout = true, LayoutHash },
+			file:     "user_info" + warmUpSuffix,
+			contains: []string{"GetUserInfoFieldsMulti(ctx, keys)"},
+		},
+		{
+			name:    "list",
+			edit:    func(cfg *Config) { cfg.WarmUp, cfg.UniqueKey, cfg.List = true, "id", "room_id" },
+			wantErr: "-list 不支持 -warmup",
+		},
+		{
+			name:    "absolute output dir",
+			edit:    func(cfg *Config) { cfg.WarmUp, cfg.OutputDir = true, filepath.Join(os.TempDir(), "codec") },
+			wantErr: "-o 是相对路径",
+		},
+	})
+}
+
+func TestWarmUpCmd(t *testing.T) {
+	files, err := generateCodec(t, func(cfg *Config) { cfg.WarmUp, cfg.OutputDir = true, "app/internal/codec" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["user_info"+warmUpSuffix]; !ok {
+		t.Fatalf("warm up file not generated: %v", files)
+	}
+	content, err := os.ReadFile(filepath.Join("app", "cmd", "codec_warmup", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `codec "slp/app/internal/codec"`; !strings.Contains(string(content), want) {
+		t.Errorf("main.go does not import %s", want)
+	}
+
+	// 已存在的入口需要手动补充初始化，再次生成时跳过
+	main := filepath.Join("app", "cmd", "codec_warmup", "main.go")
+	if err = os.WriteFile(main, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 不加 -warmup 重新生成时跟着重新生成预热文件
+	warmUp := filepath.Join("app", "internal", "codec", "user_info"+warmUpSuffix)
+	if err = os.WriteFile(warmUp, []byte("package codec\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = CodecExec(testCodecConfig("app/internal/codec")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(warmUp); !strings.Contains(string(got), "func WarmUpUserInfo(") {
+		t.Errorf("%s not regenerated", warmUp)
+	}
+	if got, _ := os.ReadFile(main); string(got) != "package main\n" {
+		t.Errorf("existing main.go overwritten")
+	}
+}
@@ -4,43 +4,343 @@ import (
 	"flag"
 	"fmt"
 	"github.com/olaola-chat/slpctl/codecgen"
+	"strings"
 )
 
 // 功能1: 示例功能A - 文件处理
 type FunctionCodec struct {
+	flagset          *flag.FlagSet
 	tablename        string
 	s                int64
 	h                int64
 	d                string
 	primaryAlisaName string
 	m                string
+	o                string
+	pkg              string
+	overwrite        string
+	fields           string
+	exclude          string
+	where            stringsFlag
+	softDelete       string
+	notFound         bool
+	notFoundTtl      int64
+	jitter           int
+	ttlHook          bool
+	tier             string
+	localSize        int
+	localTtl         int64
+	keyVersion       int
+	namespace        string
+	check            bool
+	test             bool
+	list             string
+	encoding         string
+	layout           string
+	metrics          bool
+	warmUp           bool
+	shards           int
+	shardFunc        string
+	shardWidth       int
+	chunk            int
+	loadTimeout      int64
+	singleFlight     bool
+	spec             string
+}
+
+// stringsFlag 可重复指定的参数
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ";")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
 
 // flag.String("m", "slp", "给个项目的go.mod的包名")
 func (f *FunctionCodec) InitArgs(flagset *flag.FlagSet) {
-	fmt.Println("FunctionCodec.InitArgs")
+	f.flagset = flagset
 	flagset.StringVar(&f.tablename, "t", "", "会根据这个表明生成对应的cache文件")
 	flagset.Int64Var(&f.s, "s", 0, "cache 的缓存过期时间，单位s")
 	flagset.Int64Var(&f.h, "h", 0, "cache 的缓存过期时间，单位小时,默认3")
 	flagset.StringVar(&f.d, "d", "passive", "redis的那个模块的db,按业务区分。目前提供 story,property,block,user...")
 	flagset.StringVar(&f.primaryAlisaName, "uq", "id", "默认id，但你的表如果唯一索引锁uid，这里你就可以用uid")
 	flagset.StringVar(&f.m, "m", "slp", "给个项目的go.mod的包名")
+	flagset.StringVar(&f.o, "o", codecgen.DefaultOutputDir, "codec 文件的输出目录，不存在时自动创建")
+	flagset.StringVar(&f.pkg, "pkg", "", "生成文件的包名，默认取输出目录名")
+	flagset.StringVar(&f.fields, "fields", "", "One/FindAll 只查询这些列，逗号分隔")
+	flagset.StringVar(&f.exclude, "exclude", "", "One/FindAll 查询时排除这些列，逗号分隔，如 description,content")
+	flagset.Var(&f.where, "where", "One/FindAll 额外的静态查询条件，可重复指定，如 -where \"status = 1\"")
+	flagset.StringVar(&f.softDelete, "softdelete", codecgen.SoftDeleteAuto, "软删除列处理: auto 根据表结构识别 deleted 等列, off 不处理")
+	flagset.BoolVar(&f.notFound, "notfound", false, "缓存不存在的记录，One 查不到数据时返回 codec.ErrNotFound，FindAll 跳过不存在的key")
+	flagset.Int64Var(&f.notFoundTtl, "notfound-ttl", codecgen.DefaultNotFoundTtl, "不存在记录的缓存时间，单位s")
+	flagset.IntVar(&f.jitter, "jitter", 0, "过期时间随机增加 0~N% ，避免发布后缓存集中过期")
+	flagset.BoolVar(&f.ttlHook, "ttl-hook", false, "生成 TtlOf 方法，可以按记录返回不同的过期时间")
+	flagset.StringVar(&f.tier, "tier", codecgen.TierRedis, "缓存层级: redis 只用redis, local 在redis前加进程内LRU")
+	flagset.IntVar(&f.localSize, "local-size", codecgen.DefaultLocalSize, "-tier local 时进程内缓存的最大条数")
+	flagset.Int64Var(&f.localTtl, "local-ttl", codecgen.DefaultLocalTtl, "-tier local 时进程内缓存的过期时间，单位s")
+	flagset.IntVar(&f.keyVersion, "key-version", 0, "缓存key的版本，entity结构变化时加1，旧key自然过期")
+	flagset.StringVar(&f.namespace, "ns", "", "缓存key的前缀，如 room")
+	flagset.BoolVar(&f.check, "check", true, "生成前校验 pb.Entity<PbName>、dao.<PbName>、唯一键字段和 go2cache 的函数是否存在")
+	flagset.BoolVar(&f.test, "test", false, "同时生成 <表名>_codec_test.go")
+	flagset.StringVar(&f.encoding, "encoding", codecgen.EncodingProtobuf, "缓存数据的序列化格式: protobuf,json,msgpack")
+	flagset.StringVar(&f.layout, "layout", codecgen.LayoutString, "缓存数据在redis中的结构: string,hash")
+	flagset.BoolVar(&f.metrics, "metrics", false, "记录缓存命中、回源db的条数和耗时，通过 codec.SetMetrics 接入监控")
+	flagset.BoolVar(&f.warmUp, "warmup", false, "同时生成预热函数 WarmUp<PbName> 和预热的 cmd 入口")
+	flagset.IntVar(&f.shards, "shards", 0, "分表数，表名为 <表名>_00..<表名>_<N-1>，0 表示不分表")
+	flagset.StringVar(&f.shardFunc, "shard-func", codecgen.ShardMod, "根据唯一键计算分表的方式: mod,crc32")
+	flagset.IntVar(&f.shardWidth, "shard-width", 0, "分表后缀的位数，默认按分表数计算，如100个分表为2位")
+	flagset.IntVar(&f.chunk, "chunk", 0, "FindAll 每次db查询最多的key数，超过时分批查询，0 不分批")
+	flagset.Int64Var(&f.loadTimeout, "load-timeout", 0, "每次回源db的超时时间，单位ms，0 只使用调用方的ctx")
+	flagset.BoolVar(&f.singleFlight, "singleflight", false, "按key合并并发的回源")
+	flagset.StringVar(&f.list, "list", "", "生成非唯一列到唯一键列表的缓存，如 room_id")
+	flagset.StringVar(&f.spec, "spec", "", "按声明文件生成codec，如 "+codecgen.DefaultSpecFile+"，-t 为逗号分隔的表名时只生成这些表")
+	flagset.StringVar(&f.overwrite, "overwrite", string(codecgen.OverwriteMerge), "文件已存在时的策略: skip,force,prompt,merge")
+}
+
+// codecCommands codec 的子命令，如 slpctl codec list
+var codecCommands = map[string]Function{
+	"list":    &FunctionCodecList{},
+	"upgrade": &FunctionCodecUpgrade{},
+	"import":  &FunctionCodecImport{},
+	"lint":    &FunctionCodecLint{},
 }
 
 func (f *FunctionCodec) Execute() error {
+	if name := f.flagset.Arg(0); name != "" {
+		return f.executeCommand(name, f.flagset.Args()[1:])
+	}
+	if f.spec != "" {
+		return f.executeSpec()
+	}
 	if f.tablename == "" {
 		return fmt.Errorf("-t 不能为空;会根据这个表明生成对应的cache文件")
 	}
-	//pTableName string, ps, ph int64, pd string, pName, pmode string
-	codecgen.CodecExec(f.tablename, f.s, f.h, f.d, f.primaryAlisaName, f.m)
+	overwrite, err := codecgen.ParseOverwrite(f.overwrite)
+	if err != nil {
+		return err
+	}
+	cfg := codecgen.Config{
+		TableName:    f.tablename,
+		Seconds:      f.s,
+		Hours:        f.h,
+		RedisDb:      f.d,
+		UniqueKey:    f.primaryAlisaName,
+		Module:       f.m,
+		OutputDir:    f.o,
+		Package:      f.pkg,
+		Overwrite:    overwrite,
+		Fields:       codecgen.SplitList(f.fields),
+		Exclude:      codecgen.SplitList(f.exclude),
+		Where:        f.where,
+		SoftDelete:   f.softDelete,
+		NotFound:     f.notFound,
+		NotFoundTtl:  f.notFoundTtl,
+		Jitter:       f.jitter,
+		TtlHook:      f.ttlHook,
+		Tier:         f.tier,
+		LocalSize:    f.localSize,
+		LocalTtl:     f.localTtl,
+		KeyVersion:   f.keyVersion,
+		Namespace:    f.namespace,
+		SkipCheck:    !f.check,
+		Test:         f.test,
+		List:         f.list,
+		Encoding:     f.encoding,
+		Layout:       f.layout,
+		Metrics:      f.metrics,
+		WarmUp:       f.warmUp,
+		ShardCount:   f.shards,
+		ShardFunc:    f.shardFunc,
+		ShardWidth:   f.shardWidth,
+		Chunk:        f.chunk,
+		LoadTimeout:  f.loadTimeout,
+		SingleFlight: f.singleFlight,
+	}
+	// 生成前检查和输出目录中已有codec的缓存key冲突
+	conflicts, err := codecgen.LintConfigs("-t "+f.tablename, []codecgen.Config{cfg})
+	if err != nil {
+		return err
+	}
+	if err = reportKeyConflicts(conflicts); err != nil {
+		return err
+	}
+	return codecgen.CodecExec(cfg)
+}
+
+// specFlags -spec 时还可以指定的参数，其他参数需要写在声明文件中
+var specFlags = map[string]bool{"spec": true, "t": true, "overwrite": true, "check": true}
+
+// executeSpec 按声明文件生成codec
+func (f *FunctionCodec) executeSpec() error {
+	var extra []string
+	f.flagset.Visit(func(fl *flag.Flag) {
+		if !specFlags[fl.Name] {
+			extra = append(extra, "-"+fl.Name)
+		}
+	})
+	if len(extra) > 0 {
+		return fmt.Errorf("使用 -spec 时 %s 需要写在声明文件中", strings.Join(extra, ","))
+	}
+	overwrite, err := codecgen.ParseOverwrite(f.overwrite)
+	if err != nil {
+		return err
+	}
+	spec, err := codecgen.LoadSpec(f.spec)
+	if err != nil {
+		return err
+	}
+	if len(spec.Codecs) == 0 {
+		return fmt.Errorf("%s 不存在或没有声明codec", f.spec)
+	}
+	configs, err := spec.Resolve(codecgen.SplitList(f.tablename))
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.spec, err)
+	}
+	// 只生成部分表时也检查整个声明文件，避免和没有生成的codec冲突
+	all, err := spec.Configs(nil)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.spec, err)
+	}
+	conflicts, err := codecgen.LintConfigs(f.spec, all)
+	if err != nil {
+		return err
+	}
+	if err = reportKeyConflicts(conflicts); err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		cfg.Overwrite = overwrite
+		cfg.SkipCheck = !f.check
+		if err = codecgen.CodecExec(cfg); err != nil {
+			return fmt.Errorf("生成表 %s 失败: %v", cfg.TableName, err)
+		}
+	}
+	fmt.Printf("按 %s 生成了 %d 个codec\n", f.spec, len(configs))
 	return nil
 }
 
+// executeCommand 和 main 一样解析子命令的参数并执行
+func (f *FunctionCodec) executeCommand(name string, args []string) error {
+	command, exists := codecCommands[name]
+	if !exists {
+		return fmt.Errorf("未知的 codec 子命令: %s", name)
+	}
+	flagset := flag.NewFlagSet("codec "+name, flag.ContinueOnError)
+	flagset.Usage = func() {
+		fmt.Printf("Usage of codec %s:\n", name)
+		flagset.PrintDefaults()
+		command.Help()
+	}
+	command.InitArgs(flagset)
+	if err := flagset.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	return command.Execute()
+}
+
 func (f *FunctionCodec) Help() {
-	fmt.Println("功能: 状态机")
-	fmt.Println("  描述: 用户快速生成状态机的基础代码")
+	fmt.Println("功能: 表缓存codec代码生成")
+	fmt.Println("  描述: 根据db表名生成基于go2cache的redis缓存codec文件")
+	fmt.Println("        同时生成 Invalidate<PbName>/Update<PbName>/Delete<PbName>，写db后删除对应的缓存")
 	fmt.Println("  参数:")
-	fmt.Println("    -j 状态机的配置json文件的目录")
-	fmt.Println("    -f 游戏状态机的默认配置文件名称")
-	fmt.Println("    -o 状态机代码输出目录")
+	fmt.Println("    -t <表名>    db表名 (必须指定)")
+	fmt.Println("    -s <秒>      缓存过期时间，单位s，优先级高于-h")
+	fmt.Println("    -h <小时>    缓存过期时间，单位小时")
+	fmt.Println("    -d <db>      redis模块的db，生成 library.Redis<Db> (默认: passive)")
+	if dbs, err := codecgen.RedisDbs(codecgen.LibraryDir); err == nil && len(dbs) > 0 {
+		fmt.Printf("                 当前项目可用: %s\n", strings.Join(dbs, ","))
+	}
+	fmt.Println("    -uq <字段>   唯一索引字段 (默认: id)")
+	fmt.Println("    -m <模块>    项目go.mod的包名 (默认: slp)")
+	fmt.Println("    -o <目录>    输出目录，不存在时自动创建 (默认: " + codecgen.DefaultOutputDir + ")")
+	fmt.Println("    -pkg <包名>  生成文件的包名 (默认: 输出目录名)")
+	fmt.Println("    -fields <列>  One/FindAll 只查询这些列，逗号分隔，唯一键会自动加入")
+	fmt.Println("    -exclude <列> One/FindAll 排除这些大字段，逗号分隔，如 description,content")
+	fmt.Println("                 能找到 app/dao/internal 或 app/model/internal 中的表定义时会校验列名")
+	fmt.Println("    -where <条件>  One/FindAll 额外的静态条件，可重复指定，如 -where \"status = 1\"")
+	fmt.Println("    -softdelete <auto|off> 能读取表结构时自动给 deleted,is_deleted,is_del,del_flag 列加上 = 0 条件 (默认: auto)")
+	fmt.Println("    -notfound      缓存不存在的记录，避免不存在的id反复回源db")
+	fmt.Println("                   One 查不到数据时返回 *NotFoundError，可用 errors.Is(err, codec.ErrNotFound) 判断")
+	fmt.Println("                   FindAll 跳过有不存在标记的key，db中没有查到的key写入不存在标记")
+	fmt.Println("    -notfound-ttl <秒> 不存在记录的缓存时间 (默认: 60)")
+	fmt.Println("    -jitter <N>    过期时间随机增加 0~N%，避免同一批写入的缓存同时过期")
+	fmt.Println("    -ttl-hook      生成 TtlOf 方法，热点数据可以返回更长的过期时间，返回0使用默认值")
+	fmt.Println("                   这两个参数需要 go2cache 提供 WithTtlFunc 选项，生成前解析 library/go2cache 确认，没有时不生成")
+	fmt.Println("    -tier <redis|local> 缓存层级，local 在redis前加一层进程内LRU，适合读多写少的配置表 (默认: redis)")
+	fmt.Println("    -local-size <N>  进程内缓存的最大条数 (默认: 1000)")
+	fmt.Println("    -local-ttl <秒>  进程内缓存的过期时间，各实例间不同步，不宜太长 (默认: 60)")
+	fmt.Println("                     local 需要 go2cache 提供 NewServer 和 WithLocal 选项，生成前解析 library/go2cache 确认，没有时不生成")
+	fmt.Println("    -key-version <N> 缓存key的版本，pb entity结构变化导致旧数据无法解析时加1 (默认: 0 不带版本)")
+	fmt.Println("    -ns <前缀>       缓存key的前缀，key格式为 [ns.]table.key.<表名>.[v<版本>.]<uq>.<id>")
+	fmt.Println("    -check=false     跳过生成前对 app/pb 的 Entity<PbName>、唯一键字段、app/dao 的 <PbName> 和 library/go2cache 的校验")
+	fmt.Println("    -test            同时生成 <表名>_codec_test.go，断言 Key 生成的字面量，并通过 <PbName>RedisCodec 的 Get/Find")
+	fmt.Println("                     在 miniredis 和 sqlmock 上测试回源、写缓存和不存在标记")
+	fmt.Println("                     library 中是 go-redis 的 *redis.Client 时 codec_testenv_test.go 自动替换 redis 连接，")
+	fmt.Println("                     否则需要手动实现 useTestRedis，未实现时跳过；db 通过注册使用 sqlmock 的 gdb 驱动，")
+	fmt.Println("                     替换 gf gen dao 生成的 dao.<PbName>.M，需要项目依赖 go-sqlmock、miniredis 和 gf")
+	fmt.Println("                     已存在测试文件时不加 -test 也会重新生成，新的参数不支持 -test 时备份为 .bak 后删除")
+	fmt.Println("    -encoding <格式> 缓存数据的序列化格式 (默认: protobuf)")
+	fmt.Println("         protobuf 缓存 pb.Entity<PbName>，通过 go2cache 读写")
+	fmt.Println("         json     缓存 app/model 的 model.<PbName>，不需要定义proto，生成 Get<PbName>/Get<PbName>Multi")
+	fmt.Println("         msgpack  同 json，使用 github.com/vmihailenco/msgpack/v5，体积更小")
+	fmt.Println("                  json/msgpack 注册到 EntityCodecMap，不支持 -notfound/-ttl-hook/-tier local/-test")
+	fmt.Println("    -layout <结构>   缓存数据在redis中的结构 (默认: string)")
+	fmt.Println("         string   每条记录一个序列化后的string")
+	fmt.Println("         hash     每条记录一个hash，每列一个field，生成 Get<PbName>Fields/Get<PbName>FieldsMulti 用 HMGET 读取部分列")
+	fmt.Println("                  FieldsMulti 用一个pipeline读取所有key，回源后的写入也在一个pipeline中")
+	fmt.Println("                  适合字段多但每次只读几列的大表，注册到 EntityCodecMap，不支持 -encoding/-notfound/-ttl-hook/-tier local/-test/-fields/-exclude")
+	fmt.Println("    -metrics         One/FindAll 记录回源db的条数和耗时，json/msgpack/hash/list 还会记录命中条数，标签为表名")
+	fmt.Println("                     protobuf 生成 Get<PbName>/Find<PbName> 包装 <PbName>RedisCodec 的 Get/Find，没有回源的条数记为命中，")
+	fmt.Println("                     需要 go2cache 把调用方的ctx传给 One/FindAll；直接调用 <PbName>RedisCodec 时不统计命中")
+	fmt.Println("                     查询db的方法改名为 one/findAll，区域名跟着改变，手动修改过的 One/FindAll 区域需要先迁移")
+	fmt.Println("                     默认不上报，在启动时调用 codec.SetMetrics 传入 Prometheus 等实现")
+	fmt.Println("    -warmup          同时生成 <表名>_codec_warmup.go，WarmUp<PbName> 按唯一键顺序分页读取整张表写入缓存，并按 qps 限速")
+	fmt.Println("                     注册到 WarmUpMap，并在 internal 同级的 cmd/codec_warmup 生成入口 (已存在时跳过)，需要补充初始化代码")
+	fmt.Println("                     protobuf 通过 <PbName>RedisCodec.Find 写入，需要 go2cache 的 Server 提供 Find(ctx, keys)")
+	fmt.Println("                     预热文件已存在时，不加 -warmup 重新生成codec也会同时重新生成它")
+	fmt.Println("                     protobuf 的codec以 proto.Marshal 的结果写入，需要与 go2cache 保存数据的格式一致")
+	fmt.Println("    -shards <N>      分表数，-t 为去掉后缀的表名，One/FindAll/Update/Delete 按唯一键路由到 dao.<PbName>00..")
+	fmt.Println("                     FindAll 按分表对key分组，每个分表查询一次；要求各分表 dao 的 Ctx 返回相同的类型")
+	fmt.Println("    -shard-func <mod|crc32> mod 为 key % N，crc32 为 crc32(十进制的key) % N (默认: mod)")
+	fmt.Println("    -shard-width <N> 分表后缀的位数 (默认: 按分表数计算，100个分表为 00..99)")
+	fmt.Println("                     分表不支持 -encoding/-layout/-warmup/-test/-list")
+	fmt.Println("    -chunk <N>       FindAll 每次db查询最多 N 个key，避免 in (?) 过长 (默认: 0 不分批)")
+	fmt.Println("    -load-timeout <毫秒> One/FindAll 回源db的超时时间，在调用方ctx的基础上设置 (默认: 0 不设置)")
+	fmt.Println("    -singleflight    按key合并并发的回源，FindAll 中其他请求正在查询的key等待它的结果，其余的key一起查询db")
+	fmt.Println("                     等待的key共享查询它的请求的结果，包括该请求ctx的取消和超时")
+	fmt.Println("                     这三个参数只支持 protobuf 的codec")
+	fmt.Println("    -list <列>       生成 <表名>_<列>_list_codec.go，缓存该列的值对应的唯一键列表，如房间内的所有 uid")
+	fmt.Println("                     注册到 ListCodecMap[\"<表名>.<列>\"]，数据仍通过 <表名>_codec.go 按唯一键读取")
+	fmt.Println("                     新增、删除记录或修改该列后需要调用 Invalidate<PbName>By<列>")
+	fmt.Println("    -overwrite <策略> 文件已存在时的策略 (默认: merge)")
+	fmt.Println("         skip   跳过已存在的文件")
+	fmt.Println("         force  直接覆盖，手动修改会丢失")
+	fmt.Println("         prompt 询问覆盖、合并还是跳过")
+	fmt.Println("         merge  重新生成，保留 slpctl:begin/end 区域内的手动修改")
+	fmt.Println("                区域外的代码被修改过、修改过的区域被改名或删除、合并后 import 不一致时不生成")
+	fmt.Println("    -spec <文件>     按声明文件生成codec，缓存策略的修改以数据的形式提交review")
+	fmt.Println("                     文件为json，codecs 中每一项对应一次生成的参数，字段名为参数的全称，如 table,seconds,redis_db,unique_key")
+	fmt.Println("                     defaults 中的字段用于codec中没有写的字段，写成零值 (如 \"not_found\": false) 可以关闭 defaults 中的选项")
+	fmt.Println("                     policy 的 min_ttl,max_ttl,dbs 在生成前检查，codec list 也会按它标记")
+	fmt.Println("                     可以同时指定 -t <表,...> 只生成部分表，以及 -overwrite 和 -check，其他参数需要写在文件中")
+	fmt.Println("                     codec import 可以从手写的codec生成该文件")
+	fmt.Println("  子命令:")
+	fmt.Println("    list         列出所有codec的表、过期时间和redis db")
+	fmt.Println("    upgrade      模板更新后，按已有codec文件中的参数重新生成")
+	fmt.Println("    import       从手写的 go2cache codec 读取参数写入声明文件，可以重新生成标准的codec")
+	fmt.Println("    lint         检查声明文件和已有codec之间的缓存key冲突，生成前也会自动检查")
+	fmt.Println("  示例:")
+	fmt.Println("    slpctl codec -t user_info -h 3 -d user -uq uid")
+	fmt.Println("    slpctl codec -t user_info -h 3 -d user -uq uid -layout hash")
+	fmt.Println("    slpctl codec -t user_log -h 1 -d user -shards 100 -shard-func crc32")
+	fmt.Println("    slpctl codec -t room_member -h 1 -d room -uq uid -list room_id")
+	fmt.Println("    slpctl codec -spec " + codecgen.DefaultSpecFile)
+	fmt.Println("    slpctl codec list -format json")
 }
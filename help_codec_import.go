@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/olaola-chat/slpctl/codecgen"
+	"path/filepath"
+)
+
+// slpctl codec import: 从手写的codec生成声明文件
+type FunctionCodecImport struct {
+	file     string
+	spec     string
+	generate bool
+	check    bool
+}
+
+func (f *FunctionCodecImport) InitArgs(flagset *flag.FlagSet) {
+	flagset.StringVar(&f.file, "f", "", "手写的 go2cache codec 文件")
+	flagset.StringVar(&f.spec, "spec", codecgen.DefaultSpecFile, "写入的声明文件，已存在时按表名合并")
+	flagset.BoolVar(&f.generate, "generate", false, "同时按识别出的参数重新生成标准的codec")
+	flagset.BoolVar(&f.check, "check", true, "生成前校验 pb.Entity<PbName>、dao.<PbName> 和唯一键字段是否存在")
+}
+
+func (f *FunctionCodecImport) Execute() error {
+	if f.file == "" {
+		return fmt.Errorf("-f 不能为空;需要指定手写的codec文件")
+	}
+	codecs, err := codecgen.ImportCodecs(f.file)
+	if err != nil {
+		return err
+	}
+	spec, err := codecgen.LoadSpec(f.spec)
+	if err != nil {
+		return err
+	}
+	for _, codec := range codecs {
+		cfg := codec.Config
+		fmt.Printf("识别到 %s: 表 %s，唯一键 %s，过期时间 %ds，redis db %s\n",
+			codec.Type, cfg.TableName, cfg.UniqueKey, cfg.Seconds, cfg.RedisDb)
+		for _, issue := range codec.Issues {
+			fmt.Printf("  注意: %s\n", issue)
+		}
+		if spec.Put(cfg) {
+			fmt.Printf("  替换了 %s 中已有的表 %s\n", f.spec, cfg.TableName)
+		}
+	}
+	if err = codecgen.WriteSpec(f.spec, spec); err != nil {
+		return err
+	}
+	fmt.Printf("已写入 %s，共 %d 个codec\n", f.spec, len(spec.Codecs))
+	if !f.generate {
+		return nil
+	}
+
+	replaced := false
+	for _, codec := range codecs {
+		cfg := codec.Config
+		cfg.SkipCheck = !f.check
+		if err = codecgen.CodecExec(cfg); err != nil {
+			return fmt.Errorf("生成 %s 失败: %v", codec.Type, err)
+		}
+		// 手写的文件和生成的文件同名时，merge 策略会先把它备份为 .bak
+		if filepath.Clean(filepath.Join(cfg.OutputDir, cfg.TableName+"_codec.go")) == filepath.Clean(f.file) {
+			replaced = true
+		}
+	}
+	if !replaced {
+		fmt.Printf("请确认后删除 %s，避免和生成的codec重复注册\n", f.file)
+	}
+	return nil
+}
+
+func (f *FunctionCodecImport) Help() {
+	fmt.Println("功能: 从手写的codec生成声明文件")
+	fmt.Println("  描述: 解析手写的 go2cache codec 文件，识别实现了 Pt/Key/Pk/One/FindAll 的类型，")
+	fmt.Println("        读取表名、唯一键、过期时间、redis db 和 FindAll 的查询条件，写入声明文件")
+	fmt.Println("        表名取 TableCodecMap 的注册，过期时间按 go2cache.WithTtl 的参数计算")
+	fmt.Println("        无法识别的参数和重新生成后缓存key的变化会逐条提示，需要手动确认声明文件")
+	fmt.Println("  参数:")
+	fmt.Println("    -f <文件>      手写的codec文件 (必须指定)")
+	fmt.Println("    -spec <文件>   写入的声明文件，已存在时替换同名的表 (默认: " + codecgen.DefaultSpecFile + ")")
+	fmt.Println("    -generate      同时在原文件所在目录重新生成标准的codec，与生成的文件同名的原文件会备份为 .bak")
+	fmt.Println("    -check=false   跳过对 app/pb 和 app/dao 的校验")
+	fmt.Println("  示例:")
+	fmt.Println("    slpctl codec import -f rpc/server/internal/cache/codec/room_codec.go")
+	fmt.Println("    slpctl codec import -f rpc/server/internal/cache/codec/room_codec.go -generate")
+}
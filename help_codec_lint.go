@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/olaola-chat/slpctl/codecgen"
+)
+
+// slpctl codec lint: 检查codec之间的缓存key冲突
+type FunctionCodecLint struct {
+	o    string
+	spec string
+}
+
+func (f *FunctionCodecLint) InitArgs(flagset *flag.FlagSet) {
+	flagset.StringVar(&f.o, "o", codecgen.DefaultOutputDir, "codec 文件所在目录，逗号分隔多个目录")
+	flagset.StringVar(&f.spec, "spec", codecgen.DefaultSpecFile, "同时检查的声明文件，不存在时跳过")
+}
+
+func (f *FunctionCodecLint) Execute() error {
+	spec, err := codecgen.LoadSpec(f.spec)
+	if err != nil {
+		return err
+	}
+	spaces, err := codecgen.SpecKeySpaces(f.spec, spec)
+	if err != nil {
+		return err
+	}
+	for _, dir := range codecgen.SplitList(f.o) {
+		existing, err := codecgen.DirKeySpaces(dir)
+		if err != nil {
+			return err
+		}
+		spaces = append(spaces, existing...)
+	}
+	conflicts := codecgen.LintKeySpaces(spaces)
+	if err = reportKeyConflicts(conflicts); err != nil {
+		return err
+	}
+	fmt.Printf("检查了 %d 个codec，%d 个警告\n", len(spaces), len(conflicts))
+	return nil
+}
+
+// reportKeyConflicts 输出缓存key的冲突，有需要阻止生成的冲突时返回错误
+func reportKeyConflicts(conflicts []codecgen.KeyConflict) error {
+	errors := 0
+	for _, c := range conflicts {
+		if c.Warning {
+			fmt.Printf("警告: %s\n", c)
+		} else {
+			errors++
+			fmt.Printf("错误: %s\n", c)
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d 处缓存key冲突，需要修改表名、-ns 或 -key-version", errors)
+	}
+	return nil
+}
+
+func (f *FunctionCodecLint) Help() {
+	fmt.Println("功能: 检查codec之间的缓存key冲突")
+	fmt.Println("  描述: 按声明文件和目录中已有的codec计算每个codec的缓存key格式，两两比较")
+	fmt.Println("        包括 -notfound 的 .nil 标记和 -list 的列表key，手写的codec按 codec import 的方式识别")
+	fmt.Println("        可能生成相同key的codec报错；前缀重叠、redis db 不同、同一张表在不同目录的codec给出警告")
+	fmt.Println("        slpctl codec 生成前会对将要生成的codec和输出目录做同样的检查，有冲突时不生成")
+	fmt.Println("  参数:")
+	fmt.Println("    -o <目录,...>  codec 文件所在目录 (默认: " + codecgen.DefaultOutputDir + ")")
+	fmt.Println("    -spec <文件>   同时检查的声明文件，不存在时跳过 (默认: " + codecgen.DefaultSpecFile + ")")
+	fmt.Println("  示例:")
+	fmt.Println("    slpctl codec lint")
+	fmt.Println("    slpctl codec lint -o rpc/server/internal/cache/codec,rpc/server/internal/cache/legacy")
+}
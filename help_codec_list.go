@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/olaola-chat/slpctl/codecgen"
+	"os"
+)
+
+// slpctl codec list: 列出已生成的codec
+type FunctionCodecList struct {
+	o      string
+	format string
+	minTtl int64
+	maxTtl int64
+	dbs    string
+	spec   string
+}
+
+func (f *FunctionCodecList) InitArgs(flagset *flag.FlagSet) {
+	flagset.StringVar(&f.o, "o", codecgen.DefaultOutputDir, "codec 文件所在目录")
+	flagset.StringVar(&f.format, "format", codecgen.FormatTable, "输出格式: table,json,csv")
+	flagset.Int64Var(&f.minTtl, "min-ttl", 0, "项目约定的最短过期时间，单位s，更短的会被标记")
+	flagset.Int64Var(&f.maxTtl, "max-ttl", 0, "项目约定的最长过期时间，单位s，更长的会被标记")
+	flagset.StringVar(&f.dbs, "dbs", "", "项目约定可以使用的redis db，逗号分隔，其他的会被标记")
+	flagset.StringVar(&f.spec, "spec", codecgen.DefaultSpecFile, "读取其中 policy 的声明文件，不存在时跳过")
+}
+
+func (f *FunctionCodecList) Execute() error {
+	infos, skipped, err := codecgen.ListCodecs(f.o)
+	if err != nil {
+		return err
+	}
+	spec, err := codecgen.LoadSpec(f.spec)
+	if err != nil {
+		return err
+	}
+	policy := codecgen.Policy{MinTtl: f.minTtl, MaxTtl: f.maxTtl, Dbs: codecgen.SplitList(f.dbs)}
+	if spec.Policy != nil {
+		policy = spec.Policy.Override(policy)
+	}
+	for _, info := range infos {
+		info.Issues = policy.Check(info)
+	}
+	if err = codecgen.WriteCodecList(os.Stdout, infos, f.format); err != nil {
+		return err
+	}
+	// json 和 csv 的输出可能被其他程序读取，跳过的文件输出到 stderr
+	out := os.Stderr
+	if f.format == "" || f.format == codecgen.FormatTable {
+		out = os.Stdout
+	}
+	codecgen.WriteSkipped(out, skipped)
+	return nil
+}
+
+func (f *FunctionCodecList) Help() {
+	fmt.Println("功能: 列出所有codec")
+	fmt.Println("  描述: 解析目录下所有 *_codec.go，输出表名、entity、唯一键、过期时间、redis db 和 key 格式")
+	fmt.Println("        手写的codec等不是 slpctl 生成的文件会列在最后的跳过列表中")
+	fmt.Println("  参数:")
+	fmt.Println("    -o <目录>       codec 文件所在目录 (默认: " + codecgen.DefaultOutputDir + ")")
+	fmt.Println("    -format <格式>  table,json,csv (默认: table)")
+	fmt.Println("    -min-ttl <秒>   过期时间小于该值的codec会在 ISSUES 中标记")
+	fmt.Println("    -max-ttl <秒>   过期时间大于该值的codec会在 ISSUES 中标记")
+	fmt.Println("    -dbs <db,...>   使用其他redis db的codec会在 ISSUES 中标记")
+	fmt.Println("    -spec <文件>    同时使用声明文件中的 policy，命令行参数覆盖其中对应的约定 (默认: " + codecgen.DefaultSpecFile + "，不存在时跳过)")
+	fmt.Println("  示例:")
+	fmt.Println("    slpctl codec list -max-ttl 86400 -dbs user,passive")
+}
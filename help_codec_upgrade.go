@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/olaola-chat/slpctl/codecgen"
+)
+
+// slpctl codec upgrade: 用当前模板重新生成已有的codec
+type FunctionCodecUpgrade struct {
+	o      string
+	tables string
+	check  bool
+}
+
+func (f *FunctionCodecUpgrade) InitArgs(flagset *flag.FlagSet) {
+	flagset.StringVar(&f.o, "o", codecgen.DefaultOutputDir, "codec 文件所在目录")
+	flagset.StringVar(&f.tables, "t", "", "只升级这些表，逗号分隔，默认全部")
+	flagset.BoolVar(&f.check, "check", true, "生成前校验 pb.Entity<PbName>、dao.<PbName> 和唯一键字段是否存在")
+}
+
+func (f *FunctionCodecUpgrade) Execute() error {
+	results, err := codecgen.UpgradeCodecs(f.o, codecgen.SplitList(f.tables), !f.check)
+	if err != nil {
+		return err
+	}
+	var changed, failed, skipped int
+	for _, result := range results {
+		switch {
+		case result.Skipped != "":
+			skipped++
+			fmt.Printf("跳过: %s: %s\n", result.File, result.Skipped)
+		case result.Err != nil:
+			failed++
+			fmt.Printf("失败: %s: %v\n", result.File, result.Err)
+		case result.Changed:
+			changed++
+			fmt.Printf("已更新: %s\n", result.File)
+		default:
+			fmt.Printf("无变化: %s\n", result.File)
+		}
+	}
+	fmt.Printf("共 %d 个codec，更新 %d 个，失败 %d 个，跳过 %d 个无法识别的文件\n", len(results)-skipped, changed, failed, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d 个codec升级失败", failed)
+	}
+	return nil
+}
+
+func (f *FunctionCodecUpgrade) Help() {
+	fmt.Println("功能: 升级已生成的codec")
+	fmt.Println("  描述: 从已有的 *_codec.go 中读取表名、过期时间、redis db、唯一键、项目包名和生成选项，")
+	fmt.Println("        用当前模板按 merge 策略重新生成，slpctl:begin/end 区域内的手动修改会被保留")
+	fmt.Println("        没有区域标记的旧文件会先备份为 .bak，手写的codec等无法识别的文件跳过")
+	fmt.Println("        只有 slpctl 生成的测试文件会跟着重新生成")
+	fmt.Println("  参数:")
+	fmt.Println("    -o <目录>     codec 文件所在目录 (默认: " + codecgen.DefaultOutputDir + ")")
+	fmt.Println("    -t <表,...>   只升级这些表 (默认: 全部)")
+	fmt.Println("    -check=false  跳过对 app/pb 和 app/dao 的校验")
+	fmt.Println("  示例:")
+	fmt.Println("    slpctl codec upgrade")
+	fmt.Println("    slpctl codec upgrade -t user_info,room")
+}
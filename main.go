@@ -47,7 +47,8 @@ func main() {
 	}
 
 	// 处理功能特定的参数
-	fmt.Printf("执行功能: %s\n", functionType)
+	// 提示信息输出到stderr，避免混入 json/csv 等输出
+	fmt.Fprintf(os.Stderr, "执行功能: %s\n", functionType)
 	flagset := flag.NewFlagSet(functionType, flag.ContinueOnError)
 	flagset.Usage = func() {
 		fmt.Printf("Usage of %s:\n", functionType)